@@ -1,39 +1,190 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 type LogCleaner struct {
-	lgr          LOGGER
-	wg           *sync.WaitGroup
-	shutdownChan chan struct{}
-	ticker       *time.Ticker
-	cfg          *CleanerConfig
+	lgr           LOGGER
+	wg            *sync.WaitGroup
+	shutdownChan  chan struct{}
+	ticker        *time.Ticker
+	cfg           *CleanerConfig
+	checkpointDir string
+	ledgerDir     string // ledgers are not kept when empty
+	logManager    *LogManager
 }
 
-func NewLogCleaner(lgr LOGGER, cfg *CleanerConfig,
-	shutdownChan chan struct{}, wg *sync.WaitGroup) (*LogCleaner, error) {
+func NewLogCleaner(lgr LOGGER, cfg *CleanerConfig, checkpointDir,
+	ledgerDir string, logManager *LogManager, shutdownChan chan struct{},
+	wg *sync.WaitGroup) (*LogCleaner, error) {
 
 	cleaner := &LogCleaner{
-		lgr:          lgr,
-		wg:           wg,
-		shutdownChan: shutdownChan,
-		ticker:       time.NewTicker(cfg.Interval),
-		cfg:          cfg,
+		lgr:           lgr,
+		wg:            wg,
+		shutdownChan:  shutdownChan,
+		ticker:        time.NewTicker(cfg.Interval),
+		cfg:           cfg,
+		checkpointDir: checkpointDir,
+		ledgerDir:     ledgerDir,
+		logManager:    logManager,
 	}
 	return cleaner, nil
 }
 
+// DeliveredFiles returns files of log stream id which were already
+// delivered to all destinations by their checkpoints in checkpointDir
+func DeliveredFiles(checkpointDir, id string, destinations int,
+	files []*LogFile) ([]*LogFile, error) {
+
+	checkpoints, err := ReadCheckpoints(checkpointDir, id, destinations)
+	if err != nil {
+		return nil, err
+	}
+	idx := len(files)
+	for _, checkpoint := range checkpoints {
+		checkpointIdx := FileIndex(files, checkpoint.FileName)
+		if checkpointIdx < 0 {
+			return nil, fmt.Errorf("Checkpointed file %q is missing",
+				checkpoint.FileName)
+		}
+		if checkpointIdx < idx {
+			idx = checkpointIdx
+		}
+	}
+	return files[:idx], nil
+}
+
+// removable returns files of the log stream which were already delivered to
+// all destinations, so they can be removed when retention allows it. With
+// a ledger, only files recorded in it are removable.
+func (c *LogCleaner) removable(dir, name string, cfg *TopicConfig,
+	files []*LogFile) []*LogFile {
+
+	delivered, err := DeliveredFiles(c.checkpointDir, StreamId(dir, name),
+		len(cfg.Destinations), files)
+	if os.IsNotExist(err) {
+		c.lgr.Debugf("No checkpoint of %q in %q, nothing is removed: %q",
+			name, dir, err)
+		return nil
+	}
+	if err != nil {
+		c.lgr.Warnf("%v of %q, nothing is removed", err, name)
+		return nil
+	}
+	if c.ledgerDir == "" {
+		return delivered
+	}
+	ledger, err := OpenLedger(c.ledgerDir, StreamId(dir, name))
+	if err != nil {
+		c.lgr.Errorf("Error reading ledger of %q in %q, nothing is "+
+			"removed: %q", name, dir, err)
+		return nil
+	}
+	recorded, err := ledger.Record(files, delivered, time.Now())
+	if err != nil {
+		c.lgr.Errorf("Error recording delivered files of %q in %q into "+
+			"ledger, nothing is removed: %q", name, dir, err)
+		return nil
+	}
+	if recorded > 0 {
+		c.lgr.Infof("Recorded %d delivered files of %q in %q", recorded,
+			name, dir)
+	}
+	var removable []*LogFile
+	for _, file := range delivered {
+		if ledger.Delivered(file) {
+			removable = append(removable, file)
+		} else {
+			c.lgr.Warnf("%q changed after it was delivered, it is kept",
+				file.Path)
+		}
+	}
+	return removable
+}
+
+// removeStaleCheckpoints removes checkpoints of destinations which were
+// removed from the log stream, they would hold back restoring of its journal
+func (c *LogCleaner) removeStaleCheckpoints(dir, name string,
+	cfg *TopicConfig) {
+
+	id := StreamId(dir, name)
+	matches, err := filepath.Glob(filepath.Join(c.checkpointDir,
+		CheckpointName(id, 0)+destinationSeparator+"*"))
+	if err != nil {
+		c.lgr.Errorf("Error listing checkpoints of %q: %q", name, err)
+		return
+	}
+	valid := make(map[string]bool)
+	for i := range cfg.Destinations {
+		valid[CheckpointName(id, i+1)] = true
+	}
+	for _, match := range matches {
+		if valid[filepath.Base(match)] {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			c.lgr.Errorf("Error removing checkpoint %q: %q", match, err)
+			continue
+		}
+		c.lgr.Infof("Removed stale checkpoint %q", match)
+	}
+}
+
+func (c *LogCleaner) cleanTopic(dir, name string, cfg *TopicConfig) {
+	// ledgers are kept also of logs without retention
+	if cfg.Retention < 0 && cfg.RetentionSize < 0 && c.ledgerDir == "" {
+		return
+	}
+	files, err := ListStreamFiles(dir, name, cfg)
+	if err != nil {
+		c.lgr.Errorf("Error listing files of %q in %q: %q", name, dir, err)
+		return
+	}
+	removable := c.removable(dir, name, cfg, files)
+	if cfg.Retention < 0 && cfg.RetentionSize < 0 {
+		return
+	}
+	var total int64
+	for _, file := range files {
+		total += file.Info.Size()
+	}
+	deadline := time.Now().Add(-cfg.Retention)
+	for _, file := range removable {
+		tooOld := cfg.Retention >= 0 && file.Info.ModTime().Before(deadline)
+		tooBig := cfg.RetentionSize >= 0 && total > cfg.RetentionSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(file.Path); err != nil {
+			c.lgr.Errorf("Error removing %q: %q", file.Path, err)
+			continue
+		}
+		total -= file.Info.Size()
+		c.lgr.Infof("Removed %q", file.Path)
+	}
+}
+
+func (c *LogCleaner) clean() {
+	c.logManager.Each(func(path string, logCfg *LogConfig) {
+		for name, topicCfg := range logCfg.Topics {
+			c.removeStaleCheckpoints(logCfg.Directory, name, topicCfg)
+			c.cleanTopic(logCfg.Directory, name, topicCfg)
+		}
+	})
+}
+
 func (c *LogCleaner) Run() {
 	c.lgr.Infof("started")
 	run := true
 	for run {
 		select {
 		case <-c.ticker.C:
-			// TODO
-			c.lgr.Errorf("NOT IMPLEMENTED walk and remove old logs")
+			c.clean()
 			break
 		case <-c.shutdownChan:
 			c.lgr.Infof("shutdown accepted")
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestLog(t *testing.T, dir, name string, size int, age time.Duration) {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, make([]byte, size), 0644))
+	mtime := time.Now().Add(-age)
+	assert.Nil(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestCleanTopic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	assert.Nil(t, os.Mkdir(logDir, 0755))
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+
+	day := 24 * time.Hour
+	writeTestLog(t, logDir, "20160101_000000_1_UTC-name.szn", 100, 10*day)
+	writeTestLog(t, logDir, "20160102_000000_1_UTC-name.szn", 100, 9*day)
+	writeTestLog(t, logDir, "20160103_000000_1_UTC-name.szn", 100, 2*day)
+	writeTestLog(t, logDir, "20160104_000000_1_UTC-name.szn", 100, 1*day)
+	writeTestLog(t, logDir, "20160105_000000_1_UTC-name.szn", 100, 0)
+	writeTestLog(t, logDir, "20160101_000000_1_UTC-other.szn", 100, 10*day)
+	assert.Nil(t, ioutil.WriteFile(
+		filepath.Join(checkpointDir, JournalName(StreamId(logDir, "name"))),
+		[]byte(`{"seek":10,"file_name":"`+logDir+
+			`/20160104_000000_1_UTC-name.szn","last_hash":""}`), 0644))
+
+	lm, err := NewLogManager(&HekadConfig{})
+	assert.Nil(t, err)
+	cleaner, err := NewLogCleaner(logrus.New(), &CleanerConfig{Interval: 1},
+		checkpointDir, "", lm, make(chan struct{}), &sync.WaitGroup{})
+	assert.Nil(t, err)
+
+	// only by age
+	cleaner.cleanTopic(logDir, "name", &TopicConfig{
+		Type: "kafkalog", Retention: 5 * day, RetentionSize: -1})
+	kafkalog, _ := GetLogType("kafkalog")
+	files, err := ListLogFiles(logDir, kafkalog.FileMatch("name", nil),
+		kafkalog.Priority(nil))
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, filepath.Join(logDir, "20160103_000000_1_UTC-name.szn"),
+		files[0].Path)
+
+	// by size never removes files which were not delivered yet
+	cleaner.cleanTopic(logDir, "name", &TopicConfig{
+		Type: "kafkalog", Retention: -1, RetentionSize: 10})
+	files, err = ListLogFiles(logDir, kafkalog.FileMatch("name", nil),
+		kafkalog.Priority(nil))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, filepath.Join(logDir, "20160104_000000_1_UTC-name.szn"),
+		files[0].Path)
+
+	_, err = os.Stat(filepath.Join(logDir, "20160101_000000_1_UTC-other.szn"))
+	assert.Nil(t, err)
+}
+
+func TestCleanTopicDestinations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	assert.Nil(t, os.Mkdir(logDir, 0755))
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+
+	day := 24 * time.Hour
+	writeTestLog(t, logDir, "20160101_000000_1_UTC-name.szn", 100, 3*day)
+	writeTestLog(t, logDir, "20160102_000000_1_UTC-name.szn", 100, 2*day)
+	writeTestLog(t, logDir, "20160103_000000_1_UTC-name.szn", 100, 1*day)
+	id := StreamId(logDir, "name")
+	writeCheckpoint := func(name, file string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(checkpointDir, name),
+			[]byte(`{"seek":10,"file_name":"`+filepath.Join(logDir, file)+
+				`","last_hash":""}`), 0644))
+	}
+	writeCheckpoint(CheckpointName(id, 0), "20160103_000000_1_UTC-name.szn")
+	writeCheckpoint(CheckpointName(id, 1), "20160102_000000_1_UTC-name.szn")
+	writeCheckpoint(CheckpointName(id, 2), "20160101_000000_1_UTC-name.szn")
+
+	lm, err := NewLogManager(&HekadConfig{})
+	assert.Nil(t, err)
+	cleaner, err := NewLogCleaner(logrus.New(), &CleanerConfig{Interval: 1},
+		checkpointDir, "", lm, make(chan struct{}), &sync.WaitGroup{})
+	assert.Nil(t, err)
+	cfg := &TopicConfig{
+		Type:          "kafkalog",
+		Retention:     time.Hour,
+		RetentionSize: -1,
+		Destinations:  []Destination{{Topic: "copy", Broker: "kafka"}},
+	}
+
+	// the removed destination is not waited for
+	cleaner.removeStaleCheckpoints(logDir, "name", cfg)
+	_, err = os.Stat(filepath.Join(checkpointDir, CheckpointName(id, 2)))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(checkpointDir, CheckpointName(id, 1)))
+	assert.Nil(t, err)
+
+	// only files delivered to all destinations are removed
+	cleaner.cleanTopic(logDir, "name", cfg)
+	kafkalog, _ := GetLogType("kafkalog")
+	files, err := ListLogFiles(logDir, kafkalog.FileMatch("name", nil),
+		kafkalog.Priority(nil))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, filepath.Join(logDir, "20160102_000000_1_UTC-name.szn"),
+		files[0].Path)
+}
@@ -3,11 +3,22 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// defaultMaxMessageSize is max_message_size of hekad.toml shipped with
+// kafkafeeder
+const defaultMaxMessageSize = 1048576
+
+// defaultBacklogNice is niceness of one-off pipelines shipping backlog
+const defaultBacklogNice = 10
+
+// defaultDashboardAddress is where hekad serves its dashboard
+const defaultDashboardAddress = "0.0.0.0:8796"
+
 type LoggingConfig struct {
 	Component string `yaml:"component"`
 	Dir       string `yaml:"dir"`
@@ -15,10 +26,43 @@ type LoggingConfig struct {
 }
 
 type HekadConfig struct {
-	MainConfPath string              `yaml:"main_conf_path"`
-	BinPath      string              `yaml:"bin_path"`
-	ConfDir      string              `yaml:"conf_dir"`
-	KafkaBrokers map[string][]string `yaml:"kafka_brokers"`
+	MainConfPath   string              `yaml:"main_conf_path"`
+	BinPath        string              `yaml:"bin_path"`
+	ConfDir        string              `yaml:"conf_dir"`
+	KafkaBrokers   map[string][]string `yaml:"kafka_brokers"`
+	MaxMessageSize int64               `yaml:"max_message_size"`
+	ShareDir       string              `yaml:"share_dir"`
+	// Fields are attached to messages of all topics
+	Fields map[string]string `yaml:"fields"`
+	// ProducerConfig are defaults of Kafka producer of all topics
+	ProducerConfig ProducerConfig `yaml:"producer"`
+	Producer       Producer       `yaml:"-"`
+	// DeadLetterDir receives records longer than MaxMessageSize, they are
+	// dropped when it is not set
+	DeadLetterDir string `yaml:"dead_letter_dir"`
+	// DashboardDir is working directory of hekad DashboardOutput, which
+	// writes its report there, no dashboard is run when it is not set
+	DashboardDir     string `yaml:"dashboard_dir"`
+	DashboardAddress string `yaml:"dashboard_address"`
+	// RedactConfig are mandatory redaction rules of all topics, they are
+	// applied before rules of topics
+	RedactConfig []kafkafeederYamlRedact `yaml:"redact"`
+	Redact       []RedactRule            `yaml:"-"`
+	// RedactSalt is prepended to values redacted by hash strategy
+	RedactSalt string `yaml:"redact_salt"`
+	// Egress caps bytes written by hekad per second, it is not limited when
+	// it is zero
+	EgressConfig string `yaml:"egress"`
+	Egress       int64  `yaml:"-"`
+	// BacklogEgress and BacklogNice limit one-off pipelines shipping
+	// backlog, so that they leave room for live logs
+	BacklogEgressConfig string `yaml:"backlog_egress"`
+	BacklogEgress       int64  `yaml:"-"`
+	BacklogNice         *int   `yaml:"backlog_nice"`
+	// OneOffTimeout stops one-off pipelines which deliver nothing for so
+	// long
+	OneOffTimeoutConfig string        `yaml:"one_off_timeout"`
+	OneOffTimeout       time.Duration `yaml:"-"`
 }
 
 type CleanerConfig struct {
@@ -35,6 +79,9 @@ type Config struct {
 	CheckpointDir string `yaml:"checkpoint_dir"`
 	JournalDir    string `yaml:"journal_dir"`
 	LogDir        string `yaml:"log_dir"`
+	// LedgerDir keeps ledgers of delivered files, they are not kept when
+	// it is empty
+	LedgerDir string `yaml:"ledger_dir"`
 
 	Hekad   HekadConfig   `yaml:"hekad"`
 	Cleaner CleanerConfig `yaml:"cleaner"`
@@ -79,6 +126,69 @@ func NewConfig(filename string) (cfg *Config, err error) {
 		return nil, fmt.Errorf("Hekad conf_dir can not be empty")
 	}
 
+	if err = validateFields(cfg.Hekad.Fields); err != nil {
+		return nil, fmt.Errorf("Hekad fields: %v", err)
+	}
+
+	if cfg.Hekad.Producer, err = newProducer(
+		&cfg.Hekad.ProducerConfig); err != nil {
+		return nil, fmt.Errorf("Hekad producer: %v", err)
+	}
+
+	if cfg.Hekad.Redact, err = newRedactRules(
+		cfg.Hekad.RedactConfig); err != nil {
+		return nil, fmt.Errorf("Hekad redact: %v", err)
+	}
+
+	if cfg.Hekad.EgressConfig != "" {
+		if cfg.Hekad.Egress, err = ParseRate(
+			cfg.Hekad.EgressConfig); err != nil {
+			return nil, fmt.Errorf("Hekad egress: %v", err)
+		}
+		if cfg.Hekad.Egress <= 0 {
+			return nil, fmt.Errorf("Hekad egress has to be positive")
+		}
+	}
+	cfg.Hekad.BacklogEgress = cfg.Hekad.Egress
+	if cfg.Hekad.BacklogEgressConfig != "" {
+		if cfg.Hekad.BacklogEgress, err = ParseRate(
+			cfg.Hekad.BacklogEgressConfig); err != nil {
+			return nil, fmt.Errorf("Hekad backlog_egress: %v", err)
+		}
+		if cfg.Hekad.BacklogEgress <= 0 {
+			return nil, fmt.Errorf("Hekad backlog_egress has to be positive")
+		}
+	}
+	if cfg.Hekad.BacklogNice == nil {
+		nice := defaultBacklogNice
+		cfg.Hekad.BacklogNice = &nice
+	}
+	if *cfg.Hekad.BacklogNice < 0 || *cfg.Hekad.BacklogNice > 19 {
+		return nil, fmt.Errorf("Hekad backlog_nice has to be from 0 to 19")
+	}
+
+	cfg.Hekad.OneOffTimeout = defaultOneOffTimeout
+	if cfg.Hekad.OneOffTimeoutConfig != "" {
+		if cfg.Hekad.OneOffTimeout, err = ParseRetention(
+			cfg.Hekad.OneOffTimeoutConfig); err != nil {
+			return nil, fmt.Errorf("Hekad one_off_timeout: %v", err)
+		}
+		if cfg.Hekad.OneOffTimeout <= 0 {
+			return nil, fmt.Errorf("Hekad one_off_timeout has to be positive")
+		}
+	}
+
+	if cfg.Hekad.DashboardAddress == "" {
+		cfg.Hekad.DashboardAddress = defaultDashboardAddress
+	}
+
+	if cfg.Hekad.MaxMessageSize == 0 {
+		cfg.Hekad.MaxMessageSize = defaultMaxMessageSize
+	}
+	if cfg.Hekad.MaxMessageSize < 0 {
+		return nil, fmt.Errorf("Hekad max_message_size has to be positive")
+	}
+
 	cfg.Cleaner.Interval *= time.Second
 	if cfg.Cleaner.Interval <= 0 {
 		return nil, fmt.Errorf("Cleaner interval has to be positive value in"+
@@ -93,3 +203,9 @@ func NewConfig(filename string) (cfg *Config, err error) {
 
 	return
 }
+
+// ReportPath returns heka_report.json written by hekad DashboardOutput into
+// the data directory in its working directory
+func (cfg *HekadConfig) ReportPath() string {
+	return filepath.Join(cfg.DashboardDir, "data", "heka_report.json")
+}
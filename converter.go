@@ -1,34 +1,50 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 const heka_template = `
-[KafkaOutput_{{.Id}}]
+{{range .Outputs}}[{{.Name}}]
 type = "KafkaOutput"
-message_matcher = "Type == '{{.Id}}'"
-encoder = "Encoder_{{.Id}}"
-addrs = {{.Output.Brokers}}
-partitioner = "Hash"
-hash_variable = "Fields[key]"
-topic = "{{.Output.Topic}}"
-required_acks = "{{.Output.Ack}}"
-on_error = "Retry"
-error_tries = 0
-error_timeout = 10000
-create_checkpoints = true
-checkpoint_interval = 60
-max_buffered_bytes = 102400
-max_buffer_time = 15000
-
-[Decoder_{{.Id}}]
-{{.Decoder}}
+message_matcher = {{toml .Matcher}}
+encoder = "Encoder_{{$.Id}}"
+addrs = {{.Brokers}}
+{{.Partitioner}}
+{{with .TopicVariable}}topic_variable = "{{.}}"
+{{else}}topic = "{{.Topic}}"
+{{end}}required_acks = "{{.Ack}}"
+on_error = "{{.OnError}}"
+error_tries = {{.ErrorTries}}
+{{with .DivertType}}divert_type = "{{.}}"
+divert_error_field = "dead_letter_reason"
+{{end}}error_timeout = {{.Producer.ErrorTimeoutMs}}
+create_checkpoints = {{.Checkpoints}}
+{{with .CheckpointName}}checkpoint_name = "{{.}}"
+{{end}}checkpoint_interval = {{.Producer.CheckpointIntervalSec}}
+max_buffered_bytes = {{.Producer.MaxBufferedBytes}}
+max_buffer_time = {{.Producer.MaxBufferTimeMs}}
+{{with .Producer.CompressionCodec}}compression_codec = "{{.}}"
+{{end}}
+{{end}}{{range .Sections}}[{{.Name}}]
+{{.Config}}
 
+{{end}}[Decoder_{{.Id}}]
+{{.Decoder}}
+{{range .SubDecoders}}
+[{{.Name}}]
+{{.Config}}
+{{end}}
 [Encoder_{{.Id}}]
 {{.Encoder}}
 
@@ -42,32 +58,68 @@ decoder = "Decoder_{{.Id}}"
 log_directory = "{{.Input.Directory}}"
 file_match = '{{.Input.FileMatch}}'
 priority = {{.Input.Priority}}
+source_file_field = "` + sourceFileField + `"
 `
 const replacement = "#"
 
+// sourceFileField is the message field logstreamer sets to the path of the
+// file a record was read from
+const sourceFileField = "source_file"
+
+// suffixes of counters of messages dropped by filters, by sampling and by
+// on_error
+const (
+	filteredSuffix = "-filtered"
+	sampledSuffix  = "-sampled"
+	droppedSuffix  = "-dropped"
+)
+
 var idregexp *regexp.Regexp
 
+// tomlKeyRegexp matches toml bare keys
+var tomlKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
 func init() {
 	idregexp = regexp.MustCompile(`[^a-zA-Z\-_0-9]`)
 }
 
 type Converter struct {
-	hekaTemplate *template.Template
-	brokers      map[string]string
+	hekaTemplate   *template.Template
+	brokers        map[string]string
+	fields         map[string]string
+	producer       Producer
+	deadLetterDir  string
+	maxMessageSize int64
+	redact         []RedactRule // mandatory rules of all topics
+	redactSalt     string
+	// hekad configuration of its dashboard
+	dashboard *HekadConfig
 }
 
-func NewConverter(brokers map[string][]string) (*Converter, error) {
-	hekaTemplate, err := template.New("heka_conf").Parse(heka_template)
+func NewConverter(cfg *HekadConfig) (*Converter, error) {
+	hekaTemplate, err := template.New("heka_conf").Funcs(template.FuncMap{
+		"toml": tomlString,
+	}).Parse(heka_template)
 	if err != nil {
 		return nil, err
 	}
 	brokersStr := make(map[string]string)
-	for key, br := range brokers {
+	for key, br := range cfg.KafkaBrokers {
 		brokersStr[key] = `["` + strings.Join(br, `","`) + `"]`
 	}
 	cnv := &Converter{
-		hekaTemplate: hekaTemplate,
-		brokers:      brokersStr,
+		hekaTemplate:   hekaTemplate,
+		brokers:        brokersStr,
+		fields:         cfg.Fields,
+		producer:       defaultProducer.Merge(cfg.Producer),
+		deadLetterDir:  cfg.DeadLetterDir,
+		maxMessageSize: cfg.MaxMessageSize,
+		redact:         cfg.Redact,
+		redactSalt:     cfg.RedactSalt,
+		dashboard:      cfg,
+	}
+	if cnv.maxMessageSize == 0 {
+		cnv.maxMessageSize = defaultMaxMessageSize
 	}
 	return cnv, nil
 }
@@ -76,69 +128,582 @@ func IdFromString(str string) string {
 	return string(idregexp.ReplaceAll([]byte(str), []byte(replacement)))
 }
 
+// StreamId returns id of log stream name in directory dir
+func StreamId(dir, name string) string {
+	return IdFromString(dir + name)
+}
+
+// RouteId returns id of route of log stream id used in names of its output
+// and dead letters, routes are numbered from 1
+func RouteId(id string, route int) string {
+	return id + destinationSeparator + "route" + strconv.Itoa(route)
+}
+
+// OutputId returns id of destination of log stream id used in names of its
+// output and dead letters, the main destination is 0
+func OutputId(id string, destination int) string {
+	if destination == 0 {
+		return id
+	}
+	return id + destinationSeparator + strconv.Itoa(destination)
+}
+
+// tomlString formats str as toml basic string
+func tomlString(str string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for _, r := range str {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tomlStringList formats list as toml array of strings
+func tomlStringList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, item := range list {
+		quoted[i] = tomlString(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// SideOutput is a topic receiving messages of type other than the stream id
+type SideOutput struct {
+	Suffix string // suffix of message type and of output name
+	Topic  string
+}
+
+// SideOutputsType is implemented by log types which send some messages into
+// other topics than the main one
+type SideOutputsType interface {
+	SideOutputs(cfg *TopicConfig) []SideOutput
+}
+
+type OutputData struct {
+	Name        string
+	Matcher     string
+	Partitioner string
+	Topic       string
+	// TopicVariable is a message variable with name of topic, it is used
+	// instead of Topic when set
+	TopicVariable string
+	Brokers       string
+	Ack           string
+	Checkpoints   bool
+	// CheckpointName is set for additional destinations, which track their
+	// progress in their own checkpoints
+	CheckpointName string
+	Producer       Producer
+	OnError        string
+	ErrorTries     int
+	DivertType     string // type of messages failed after ErrorTries
+}
+
+type SectionData struct {
+	Name   string
+	Config string
+}
+
 type TemplateData struct {
-	Id       string
-	Decoder  string
-	Encoder  string
-	Splitter string
-	Output   struct {
-		Topic   string
-		Brokers string
-		Ack     string
-	}
-	Input struct {
+	Id          string
+	Decoder     string
+	SubDecoders []SectionData
+	Encoder     string
+	Splitter    string
+	Outputs     []OutputData
+	Sections    []SectionData // other sections of outputs
+	Input       struct {
 		Directory string
 		FileMatch string
 		Priority  string
 	}
 }
 
+// mergeFields returns global fields overridden by topic fields
+func mergeFields(global, topic map[string]string) map[string]string {
+	fields := make(map[string]string, len(global)+len(topic))
+	for name, value := range global {
+		fields[name] = value
+	}
+	for name, value := range topic {
+		fields[name] = value
+	}
+	return fields
+}
+
+// fieldsScribbleDecoder returns configuration of decoder section name which
+// sets static fields to every message
+func fieldsScribbleDecoder(name string, fields map[string]string) string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	decoder := `type = "ScribbleDecoder"` + "\n" +
+		fmt.Sprintf("[%s.message_fields]", name)
+	for _, field := range names {
+		decoder += fmt.Sprintf("\n%s = %s", field, tomlString(fields[field]))
+	}
+	return decoder
+}
+
+// encoder returns configuration of hekad encoder section name, stream
+// identifies the log stream in JSON envelopes
+func encoder(name, stream, encoding string) string {
+	switch encoding {
+	case ENCODING_JSON:
+		return `type = "SandboxEncoder"` + "\n" +
+			`filename = "kafkafeeder/json_envelope.lua"` + "\n" +
+			fmt.Sprintf("[%s.config]\n", name) +
+			fmt.Sprintf("stream = %s", tomlString(stream))
+	case ENCODING_PROTOBUF:
+		return `type = "ProtobufEncoder"`
+	}
+	return `type = "PayloadEncoder"` + "\n" +
+		`append_newlines = false`
+}
+
+// partitioner returns configuration of KafkaOutput partitioner
+func partitioner(cfg *TopicConfig, logType LogType) string {
+	p := cfg.Partitioning
+	if p.Strategy == PARTITIONING_AUTO {
+		p.Strategy = PARTITIONING_RANDOM
+		typeFields, ok := logType.(LogTypeFields)
+		if ok && oneOf("key", typeFields.Fields(cfg)) {
+			p = Partitioning{Strategy: PARTITIONING_HASH, Field: "key"}
+		}
+	}
+	switch p.Strategy {
+	case PARTITIONING_ROUND_ROBIN:
+		return `partitioner = "RoundRobin"`
+	case PARTITIONING_HASH:
+		return `partitioner = "Hash"` + "\n" +
+			fmt.Sprintf(`hash_variable = "Fields[%s]"`, p.Field)
+	case PARTITIONING_SOURCE:
+		return `partitioner = "Hash"` + "\n" +
+			fmt.Sprintf(`hash_variable = "Fields[%s]"`, sourceFileField)
+	}
+	return `partitioner = "Random"`
+}
+
+// deadLetterSections returns sections writing dead letters of log stream id
+// into kafkalog files in spool and their reasons into sidecar files
+func deadLetterSections(id, spool string) []SectionData {
+	msgType := id + deadLetterSuffix
+	output := func(path, encoder string) string {
+		return `type = "FileOutput"` + "\n" +
+			fmt.Sprintf("message_matcher = \"Type == '%s'\"\n", msgType) +
+			fmt.Sprintf("path = %s\n", tomlString(path)) +
+			fmt.Sprintf("rotation_interval = %d\n",
+				deadLetterRotation/time.Hour) +
+			fmt.Sprintf("encoder = %s", tomlString(encoder))
+	}
+	path := filepath.Join(spool, deadLetterFile(id))
+	return []SectionData{{
+		Name:   "Encoder_" + msgType,
+		Config: `type = "KafkalogEncoder"`,
+	}, {
+		Name: "Encoder_" + msgType + "_reason",
+		Config: `type = "SandboxEncoder"` + "\n" +
+			`filename = "kafkafeeder/dead_letter_reason.lua"`,
+	}, {
+		Name:   "FileOutput_" + msgType,
+		Config: output(path, "Encoder_"+msgType),
+	}, {
+		Name: "FileOutput_" + msgType + "_reason",
+		Config: output(DeadLetterReasons(path),
+			"Encoder_"+msgType+"_reason"),
+	}, {
+		Name:   "SandboxOutput_" + msgType,
+		Config: counterOutput(fmt.Sprintf("Type == '%s'", msgType)),
+	}}
+}
+
+// onErrorOutput sets on_error of KafkaOutput output of destination id by
+// policy and returns sections for its failed messages. The KafkaOutput fork
+// reinjects messages failed after error_tries as divert_type with the error
+// in Fields[dead_letter_reason]. Dropped messages are diverted into
+// a counter, so that status reports them. Diverted messages are spooled,
+// sections of the spool are returned unless spooled is set.
+func onErrorOutput(output *OutputData, id string, policy OnError,
+	spool string, spooled bool) []SectionData {
+
+	output.OnError = "Retry"
+	output.ErrorTries = 0
+	output.DivertType = ""
+	switch policy.Action {
+	case ON_ERROR_DROP:
+		output.OnError = "Divert"
+		output.ErrorTries = policy.Tries
+		output.DivertType = id + droppedSuffix
+		return []SectionData{{
+			Name: "SandboxOutput_" + output.DivertType,
+			Config: counterOutput(fmt.Sprintf("Type == '%s'",
+				output.DivertType)),
+		}}
+	case ON_ERROR_DIVERT:
+		output.OnError = "Divert"
+		output.ErrorTries = policy.Tries
+		output.DivertType = id + deadLetterSuffix
+		if !spooled {
+			return deadLetterSections(id, spool)
+		}
+	}
+	return nil
+}
+
+// uuidBuckets is number of buckets of messages sampled by four hexadecimal
+// digits of their random uuid
+const uuidBuckets = 1 << 16
+
+// filterMatchers returns heka message matchers of messages of log stream id
+// which are sent to Kafka, which are dropped by include and exclude filters
+// and which are dropped by sampling. Matchers of dropped messages are empty
+// when nothing is dropped.
+func filterMatchers(id string, f Filter) (sent, filtered, sampled string,
+	err error) {
+
+	base := fmt.Sprintf("Type == '%s'", id)
+	sent = base
+	var dropped []string
+	if f.Include != "" {
+		sent += " && (" + f.Include + ")"
+		negated, err := negateMatcher(f.Include)
+		if err != nil {
+			return "", "", "", err
+		}
+		dropped = append(dropped, negated)
+	}
+	if f.Exclude != "" {
+		negated, err := negateMatcher(f.Exclude)
+		if err != nil {
+			return "", "", "", err
+		}
+		sent += " && " + negated
+		dropped = append(dropped, "("+f.Exclude+")")
+	}
+	if len(dropped) > 0 {
+		filtered = base + " && (" + strings.Join(dropped, " || ") + ")"
+	}
+	threshold := int(math.Ceil(f.Sample / 100 * uuidBuckets))
+	if f.Sample > 0 && threshold < uuidBuckets {
+		sampled = fmt.Sprintf("%s && Uuid >= '%04x'", sent, threshold)
+		sent = fmt.Sprintf("%s && Uuid < '%04x'", sent, threshold)
+	}
+	return sent, filtered, sampled, nil
+}
+
+// counterOutput returns configuration of output counting messages matching
+// matcher, hekad reports the count as ProcessMessageCount
+func counterOutput(matcher string) string {
+	return `type = "SandboxOutput"` + "\n" +
+		`filename = "kafkafeeder/count.lua"` + "\n" +
+		fmt.Sprintf("message_matcher = %s", tomlString(matcher))
+}
+
+// routesByField reports whether some of routes send messages to topics in
+// a field
+func routesByField(routes []Route) bool {
+	for _, route := range routes {
+		if route.TopicField != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultOutputLimit is output_limit of heka sandboxes
+const defaultOutputLimit = 64 << 10
+
+// sandboxOutputLimit returns output_limit of sandboxes producing messages of
+// records up to size bytes. A message can hold the record twice, e.g. syslog
+// with json payload has it also in fields, and the default limit is left for
+// the rest of the message.
+func sandboxOutputLimit(size int64) int64 {
+	return 2*size + defaultOutputLimit
+}
+
+// withOutputLimit sets output_limit of a sandbox section config unless it is
+// set already, other sections are returned unchanged
+func withOutputLimit(config string, limit int64) string {
+	if !strings.HasPrefix(config, `type = "Sandbox`) ||
+		strings.Contains(config, "\noutput_limit = ") {
+		return config
+	}
+	line := fmt.Sprintf("output_limit = %d", limit)
+	// options of the script are the last table of the section
+	if i := strings.Index(config, "\n["); i >= 0 {
+		return config[:i+1] + line + config[i:]
+	}
+	return config + "\n" + line
+}
+
+// multiDecoder returns configuration of MultiDecoder of subs applied by
+// cascade strategy
+func multiDecoder(subs []SectionData, strategy string) string {
+	names := make([]string, len(subs))
+	for i, sub := range subs {
+		names[i] = sub.Name
+	}
+	return `type = "MultiDecoder"` + "\n" +
+		fmt.Sprintf("subs = %s\n", tomlStringList(names)) +
+		fmt.Sprintf("cascade_strategy = %s", tomlString(strategy))
+}
+
+// oversizeDecoder returns configuration of decoder section name which
+// marks records truncated by the splitter as dead letters of type msgType
+// and fails for the others
+func oversizeDecoder(name, msgType string, maxSize int64) string {
+	return `type = "SandboxDecoder"` + "\n" +
+		`filename = "kafkafeeder/oversize.lua"` + "\n" +
+		fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("type = %s\n", tomlString(msgType)) +
+		fmt.Sprintf("max_size = %d", maxSize)
+}
+
+func ackName(ack int) (string, error) {
+	switch ack {
+	case ACK_DISABLED:
+		return `NoResponse`, nil
+	case ACK_MEMORY_WRITE:
+		return `WaitForLocal`, nil
+	case ACK_DISK_WRITE:
+		return `WaitForAll`, nil
+	}
+	return "", fmt.Errorf("Convert Topic: unsupported ack level %q", ack)
+}
+
 func (c *Converter) ConvertTopic(name, dir string, cfg *TopicConfig,
 	wr io.Writer) error {
 
 	data := TemplateData{}
-	data.Id = IdFromString(dir + name)
-	data.Output.Topic = cfg.Topic
-	data.Encoder = `type = "PayloadEncoder"` + "\n" +
-		`append_newlines = false`
+	data.Id = StreamId(dir, name)
+	// sandboxes have to output whole records, which splitters cut at
+	// max_message_size
+	outputLimit := sandboxOutputLimit(c.maxMessageSize)
+	data.Encoder = withOutputLimit(encoder("Encoder_"+data.Id,
+		filepath.Join(dir, name), cfg.Encoding), outputLimit)
 	data.Input.Directory = dir
-	switch cfg.Type {
-	case "kafkalog":
-		data.Input.FileMatch = fmt.Sprintf(
-			`(?P<Date>\d+)_(?P<Time>\d+)_\d+_UTC-%s\.szn`, name)
-		data.Input.Priority = `["Date", "Time"]`
-		data.Decoder = fmt.Sprintf(
-			`type = "KafkalogDecoder"`+"\n"+
-				`msg_type = "%s"`, data.Id)
-		data.Splitter = `type = "KafkalogSplitter"`
-		break
-	default:
+	logType, ok := GetLogType(cfg.Type)
+	if !ok {
 		return fmt.Errorf("Convert Topic: unsupported type %q", cfg.Type)
 	}
+	data.Input.FileMatch = logType.FileMatch(name, cfg)
+	data.Input.Priority = tomlStringList(logType.Priority(cfg))
+	data.Splitter = logType.Splitter(cfg)
+	// records longer than max_message_size are spooled instead of being
+	// dropped by the splitter, keep_truncated is a splitter option of heka
+	// since 0.10. The spool belongs to the main topic, so streams routed to
+	// topics in a field do not spool records of other topics there.
+	var spoolType string
+	if c.deadLetterDir != "" && !routesByField(cfg.Routes) {
+		spoolType = data.Id + deadLetterSuffix
+		data.Sections = deadLetterSections(data.Id,
+			DeadLetterSpool(c.deadLetterDir, cfg.Broker, cfg.Topic))
+		if !strings.Contains(data.Splitter, "keep_truncated") {
+			data.Splitter += "\nkeep_truncated = true"
+		}
+	}
+
+	// decode by the log type, then add static fields and redact sensitive
+	// data
+	logDecoder := SectionData{Name: "Decoder_" + data.Id + "_log"}
+	chain := []SectionData{logDecoder}
+	if fields := mergeFields(c.fields, cfg.Fields); len(fields) > 0 {
+		name := "Decoder_" + data.Id + "_fields"
+		chain = append(chain, SectionData{
+			Name: name, Config: fieldsScribbleDecoder(name, fields)})
+	}
+	// mandatory rules come first, so that topic rules see only redacted
+	// data and can not change what they apply to
+	rules := append(append([]RedactRule{}, c.redact...), cfg.Redact...)
+	if len(rules) > 0 {
+		name := "Decoder_" + data.Id + "_redact"
+		config, err := redactDecoder(name, rules, c.redactSalt)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, SectionData{Name: name, Config: config})
+	}
+	if len(chain) == 1 && spoolType == "" {
+		data.Decoder = withOutputLimit(logType.Decoder("Decoder_"+data.Id,
+			data.Id, cfg), outputLimit)
+	} else {
+		chain[0].Config = logType.Decoder(logDecoder.Name, data.Id, cfg)
+		data.SubDecoders = chain
+		data.Decoder = multiDecoder(chain, "all")
+		// records truncated by the splitter are spooled as they were read,
+		// decoders would change them. The first decoder succeeds only for
+		// them, the others decode the rest.
+		if spoolType != "" {
+			name := "Decoder_" + data.Id + "_oversize"
+			oversize := SectionData{Name: name, Config: oversizeDecoder(
+				name, spoolType, c.maxMessageSize)}
+			decode := chain[0]
+			if len(chain) > 1 {
+				decode = SectionData{Name: "Decoder_" + data.Id + "_decode",
+					Config: data.Decoder}
+				data.SubDecoders = append(data.SubDecoders, decode)
+			}
+			data.SubDecoders = append([]SectionData{oversize},
+				data.SubDecoders...)
+			data.Decoder = multiDecoder([]SectionData{oversize, decode},
+				"first-wins")
+		}
+		for i, decoder := range data.SubDecoders {
+			data.SubDecoders[i].Config = withOutputLimit(decoder.Config,
+				outputLimit)
+		}
+	}
 
-	var ok bool
-	data.Output.Brokers, ok = c.brokers[cfg.Broker]
+	brokers, ok := c.brokers[cfg.Broker]
 	if !ok {
 		return fmt.Errorf("Convert Topic: unsupported broker %q", cfg.Broker)
 	}
-
-	switch cfg.Ack {
-	case ACK_DISABLED:
-		data.Output.Ack = `NoResponse`
-		break
-	case ACK_MEMORY_WRITE:
-		data.Output.Ack = `WaitForLocal`
-		break
-	case ACK_DISK_WRITE:
-		data.Output.Ack = `WaitForAll`
-		break
-	default:
-		return fmt.Errorf("Convert Topic: unsupported ack level %q", cfg.Ack)
+	ack, err := ackName(cfg.Ack)
+	if err != nil {
+		return err
+	}
+	producer := c.producer.Merge(cfg.Producer)
+	matcher, filtered, sampled, err := filterMatchers(data.Id, cfg.Filter)
+	if err != nil {
+		return err
+	}
+	if filtered != "" {
+		data.Sections = append(data.Sections, SectionData{
+			Name:   "SandboxOutput_" + data.Id + filteredSuffix,
+			Config: counterOutput(filtered),
+		})
+	}
+	if sampled != "" {
+		data.Sections = append(data.Sections, SectionData{
+			Name:   "SandboxOutput_" + data.Id + sampledSuffix,
+			Config: counterOutput(sampled),
+		})
+	}
+	primary := OutputData{
+		Name:        "KafkaOutput_" + data.Id,
+		Matcher:     matcher,
+		Partitioner: partitioner(cfg, logType),
+		Topic:       cfg.Topic,
+		Brokers:     brokers,
+		Ack:         ack,
+		Checkpoints: true,
+		Producer:    producer,
+	}
+	// messages rejected by Kafka are spooled by default, so that they do
+	// not block the stream, retry has to be chosen explicitly
+	onError := cfg.OnError
+	if onError.Action == "" {
+		onError = OnError{Action: ON_ERROR_RETRY}
+		if spoolType != "" {
+			onError = OnError{Action: ON_ERROR_DIVERT,
+				Tries: defaultErrorTries}
+		}
+	}
+	data.Sections = append(data.Sections, onErrorOutput(&primary, data.Id,
+		onError, DeadLetterSpool(c.deadLetterDir, cfg.Broker, cfg.Topic),
+		spoolType != "")...)
+	data.Outputs = append(data.Outputs, primary)
+	// the log is read once, every destination saves its own checkpoint
+	for i, dest := range cfg.Destinations {
+		id := OutputId(data.Id, i+1)
+		output := primary
+		output.Name = "KafkaOutput_" + id
+		output.Topic = dest.Topic
+		if output.Brokers, ok = c.brokers[dest.Broker]; !ok {
+			return fmt.Errorf("Convert Topic: unsupported broker %q",
+				dest.Broker)
+		}
+		if output.Ack, err = ackName(dest.Ack); err != nil {
+			return err
+		}
+		output.CheckpointName = CheckpointName(data.Id, i+1)
+		data.Sections = append(data.Sections, onErrorOutput(&output, id,
+			onError, DeadLetterSpool(c.deadLetterDir, dest.Broker,
+				dest.Topic), false)...)
+		data.Outputs = append(data.Outputs, output)
+	}
+	for i, route := range cfg.Routes {
+		id := RouteId(data.Id, i+1)
+		output := primary
+		output.Name = "KafkaOutput_" + id
+		output.Matcher = fmt.Sprintf("%s && (%s)", matcher, route.Match)
+		output.Topic = route.Topic
+		if route.TopicField != "" {
+			output.TopicVariable = fmt.Sprintf("Fields[%s]", route.TopicField)
+			output.Matcher += fmt.Sprintf(" && Fields[%s] != NIL",
+				route.TopicField)
+		}
+		if output.Brokers, ok = c.brokers[route.Broker]; !ok {
+			return fmt.Errorf("Convert Topic: unsupported broker %q",
+				route.Broker)
+		}
+		if output.Ack, err = ackName(route.Ack); err != nil {
+			return err
+		}
+		// only some messages are routed, so checkpoints of routes would
+		// hold back the whole stream
+		output.Checkpoints = false
+		data.Sections = append(data.Sections, onErrorOutput(&output, id,
+			onError, DeadLetterSpool(c.deadLetterDir, route.Broker,
+				route.Topic), false)...)
+		data.Outputs = append(data.Outputs, output)
+	}
+	if sideOutputs, ok := logType.(SideOutputsType); ok {
+		for _, side := range sideOutputs.SideOutputs(cfg) {
+			data.Outputs = append(data.Outputs, OutputData{
+				Name:    "KafkaOutput_" + data.Id + side.Suffix,
+				Matcher: fmt.Sprintf("Type == '%s%s'", data.Id, side.Suffix),
+				// side messages do not have fields of the main ones
+				Partitioner: `partitioner = "Random"`,
+				Topic:       side.Topic,
+				Brokers:     brokers,
+				Ack:         ack,
+				// checkpoints track only the main topic
+				Checkpoints: false,
+				Producer:    producer,
+				OnError:     "Retry",
+			})
+		}
 	}
 
 	return c.hekaTemplate.Execute(wr, data)
 }
 
+// ConvertDashboard writes DashboardOutput of hekad, its working directory is
+// set so that status finds its report. Nothing is written when dashboard_dir
+// is not set.
+func (c *Converter) ConvertDashboard(wr io.Writer) error {
+	cfg := c.dashboard
+	if cfg.DashboardDir == "" {
+		return nil
+	}
+	address := cfg.DashboardAddress
+	if address == "" {
+		address = defaultDashboardAddress
+	}
+	dashboard := "[DashboardOutput]\n" +
+		fmt.Sprintf("address = %s\n", tomlString(address)) +
+		fmt.Sprintf("working_directory = %s\n",
+			tomlString(cfg.DashboardDir))
+	if cfg.ShareDir != "" {
+		dashboard += fmt.Sprintf("static_directory = %s\n",
+			tomlString(filepath.Join(cfg.ShareDir, "dasher")))
+	}
+	_, err := io.WriteString(wr, dashboard)
+	return err
+}
+
 func (c *Converter) Convert(cfg *LogConfig, wr io.Writer) (err error) {
 	for name, topicCfg := range cfg.Topics {
 		if err = c.ConvertTopic(name, cfg.Directory, topicCfg, wr); err != nil {
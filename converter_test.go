@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,8 +19,11 @@ func TestConvertString(t *testing.T) {
 		Ack:       ACK_DISK_WRITE,
 	}
 	var b bytes.Buffer
-	c, err := NewConverter(map[string][]string{
-		"kafka": []string{"kafka1.dev:9092", "kafka2.dev:9092", "kafka3.dev:9092"},
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{
+				"kafka1.dev:9092", "kafka2.dev:9092", "kafka3.dev:9092"},
+		},
 	})
 	assert.Nil(t, err)
 	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
@@ -59,5 +64,868 @@ decoder = "Decoder_#tmpname"
 log_directory = "/tmp"
 file_match = '(?P<Date>\d+)_(?P<Time>\d+)_\d+_UTC-name\.szn'
 priority = ["Date", "Time"]
+source_file_field = "source_file"
+`)
+}
+
+func TestConvertPlaintext(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "plaintext",
+		Broker: "kafka",
+		Ack:    ACK_MEMORY_WRITE,
+		Options: &plaintextOptions{fileOptions{
+			FileMatch: `app\.log\.?(?P<Index>\d+)?`,
+			Priority:  []string{"^Index"},
+		}},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "ScribbleDecoder"
+[Decoder_#tmpname.message_fields]
+Type = "#tmpname"
+`)
+	assert.Contains(t, b.String(), `
+[Splitter_#tmpname]
+type = "TokenSplitter"
+delimiter = '\n'
+`)
+	assert.Contains(t, b.String(), `
+file_match = 'app\.log\.?(?P<Index>\d+)?'
+priority = ["^Index"]
+`)
+
+	cfg.Options = nil
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+file_match = 'name\.log'
+priority = []
+`)
+}
+
+func TestConvertJsonlines(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "jsonlines",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Options: &jsonlinesOptions{
+			Key:            "user",
+			MalformedTopic: "topic-malformed",
+		},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "SandboxDecoder"
+filename = "kafkafeeder/jsonlines.lua"
+output_limit = 2162688
+[Decoder_#tmpname.config]
+type = "#tmpname"
+key_field = "user"
+malformed_type = "#tmpname-malformed"
+`)
+	assert.Contains(t, b.String(), `
+[KafkaOutput_#tmpname-malformed]
+type = "KafkaOutput"
+message_matcher = "Type == '#tmpname-malformed'"
+encoder = "Encoder_#tmpname"
+addrs = ["kafka1.dev:9092"]
+partitioner = "Random"
+topic = "topic-malformed"
+required_acks = "WaitForAll"
+on_error = "Retry"
+error_tries = 0
+error_timeout = 10000
+create_checkpoints = false
+`)
+}
+
+func TestConvertSyslog(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:   "topic",
+		Type:    "syslog",
+		Broker:  "kafka",
+		Ack:     ACK_DISK_WRITE,
+		Options: &syslogOptions{Payload: "json"},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("messages", "/var/log", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#var#logmessages]
+type = "SandboxDecoder"
+filename = "kafkafeeder/syslog.lua"
+output_limit = 2162688
+[Decoder_#var#logmessages.config]
+type = "#var#logmessages"
+payload = "json"
+`)
+	assert.Contains(t, b.String(), `
+file_match = 'messages\.log'
+`)
+}
+
+func TestConvertMultiline(t *testing.T) {
+	options := &multilineOptions{Start: `^\d{4}-\d{2}-\d{2} `, maxSize: 65536}
+	cfg := TopicConfig{
+		Topic:   "topic",
+		Type:    "multiline",
+		Broker:  "kafka",
+		Ack:     ACK_DISK_WRITE,
+		Options: options,
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Splitter_#tmpname]
+type = "RegexSplitter"
+delimiter = '\n((?m:^\d{4}-\d{2}-\d{2} ))'
+delimiter_eol = false
+keep_truncated = true
+`)
+	assert.Contains(t, b.String(), `
+filename = "kafkafeeder/multiline.lua"
+output_limit = 196608
+[Decoder_#tmpname.config]
+type = "#tmpname"
+max_size = 65536
+`)
+
+	// anchors of all alternatives match after the new line
+	options.Start = `^ERROR|^WARN`
+	delimiter := regexp.MustCompile(`delimiter = '(.*)'`).FindStringSubmatch(
+		(&multilineType{}).Splitter(&cfg))[1]
+	assert.Equal(t, regexp.MustCompile(delimiter).FindAllString("ERROR a\nb\nWARN c\nERROR d", -1),
+		[]string{"\nWARN", "\nERROR"})
+}
+
+func TestConvertCustom(t *testing.T) {
+	delimiterEol := false
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "custom",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Options: &customOptions{
+			fileOptions: fileOptions{
+				FileMatch: `(?P<Year>\d+)/(?P<Day>\d+)\.log`,
+				Priority:  []string{"Year", "Day"},
+			},
+			Splitter:     "regex",
+			Delimiter:    `\n(BEGIN)`,
+			DelimiterEol: &delimiterEol,
+			Decoder:      "json",
+			Key:          "id",
+		},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Splitter_#tmpname]
+type = "RegexSplitter"
+delimiter = '\n(BEGIN)'
+delimiter_eol = false
+`)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "SandboxDecoder"
+filename = "kafkafeeder/jsonlines.lua"
+output_limit = 2162688
+[Decoder_#tmpname.config]
+type = "#tmpname"
+key_field = "id"
+`)
+	assert.Contains(t, b.String(), `
+file_match = '(?P<Year>\d+)/(?P<Day>\d+)\.log'
+priority = ["Year", "Day"]
+`)
+}
+
+func TestConvertLogrotate(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:   "topic",
+		Type:    "plaintext",
+		Broker:  "kafka",
+		Ack:     ACK_DISK_WRITE,
+		Options: &plaintextOptions{fileOptions{Rotation: rotationLogrotate}},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("access", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+file_match = 'access\.log(?:\.(?P<Index>\d+))?(?:\.gz)?'
+priority = ["^Index"]
+`)
+}
+
+func TestConvertLua(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "lua",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Options: &luaOptions{
+			Script: "nginx_access.lua",
+			Config: map[string]interface{}{
+				"log_format": `$remote_addr "$request"`,
+				"user_agent": true,
+			},
+		},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("access", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpaccess]
+type = "SandboxDecoder"
+filename = "lua_decoders/nginx_access.lua"
+output_limit = 2162688
+[Decoder_#tmpaccess.config]
+type = "#tmpaccess"
+log_format = "$remote_addr \"$request\""
+user_agent = true
+`)
+}
+
+func TestConvertEncoding(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:    "topic",
+		Type:     "kafkalog",
+		Broker:   "kafka",
+		Ack:      ACK_DISK_WRITE,
+		Encoding: ENCODING_JSON,
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Encoder_#tmpname]
+type = "SandboxEncoder"
+filename = "kafkafeeder/json_envelope.lua"
+output_limit = 2162688
+[Encoder_#tmpname.config]
+stream = "/tmp/name"
+`)
+	// logstreamer passes the read file to the envelope
+	assert.Contains(t, b.String(), `
+priority = ["Date", "Time"]
+source_file_field = "source_file"
+`)
+
+	cfg.Encoding = ENCODING_PROTOBUF
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Encoder_#tmpname]
+type = "ProtobufEncoder"
+`)
+}
+
+func TestConvertFields(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Fields: map[string]string{"owner": "team", "env": "dev"},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		Fields: map[string]string{"hostname": "host1", "env": "prod"},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_log", "Decoder_#tmpname_fields"]
+cascade_strategy = "all"
+
+[Decoder_#tmpname_log]
+type = "KafkalogDecoder"
+msg_type = "#tmpname"
+
+[Decoder_#tmpname_fields]
+type = "ScribbleDecoder"
+[Decoder_#tmpname_fields.message_fields]
+env = "dev"
+hostname = "host1"
+owner = "team"
+
+[Encoder_#tmpname]
+`)
+}
+
+func TestConvertPartitioning(t *testing.T) {
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	for partitioning, expected := range map[Partitioning]string{
+		Partitioning{}: `partitioner = "Random"`,
+		Partitioning{Strategy: PARTITIONING_ROUND_ROBIN}: `partitioner = ` +
+			`"RoundRobin"`,
+		Partitioning{Strategy: PARTITIONING_HASH, Field: "host"}: `partitioner` +
+			` = "Hash"` + "\nhash_variable = \"Fields[host]\"",
+		Partitioning{Strategy: PARTITIONING_SOURCE}: `partitioner = "Hash"` +
+			"\nhash_variable = \"Fields[source_file]\"",
+	} {
+		cfg := TopicConfig{
+			Topic:        "topic",
+			Type:         "plaintext",
+			Broker:       "kafka",
+			Ack:          ACK_DISK_WRITE,
+			Partitioning: partitioning,
+		}
+		var b bytes.Buffer
+		err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+		assert.Nil(t, err)
+		assert.Contains(t, b.String(), "\naddrs = [\"kafka1.dev:9092\"]\n"+
+			expected+"\ntopic = ", partitioning.Strategy)
+	}
+}
+
+func TestConvertProducer(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:    "topic",
+		Type:     "kafkalog",
+		Broker:   "kafka",
+		Ack:      ACK_DISK_WRITE,
+		Producer: Producer{MaxBufferTime: 100 * time.Millisecond},
+	}
+	var b bytes.Buffer
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		Producer: Producer{
+			MaxBufferTime: time.Minute,
+			Compression:   COMPRESSION_SNAPPY,
+		},
+	})
+	assert.Nil(t, err)
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+error_timeout = 10000
+create_checkpoints = true
+checkpoint_interval = 60
+max_buffered_bytes = 102400
+max_buffer_time = 100
+compression_codec = "Snappy"
+
+[Decoder_#tmpname]
+`)
+}
+
+func TestConvertDeadLetters(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Destinations: []Destination{
+			{Topic: "topic", Broker: "kafka", Ack: ACK_DISK_WRITE},
+		},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.NotContains(t, b.String(), "FileOutput")
+	assert.NotContains(t, b.String(), "keep_truncated")
+
+	c, err = NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		DeadLetterDir: "/var/dead-letter",
+	})
+	assert.Nil(t, err)
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	// messages rejected by Kafka are spooled after default tries
+	assert.Contains(t, b.String(), `
+on_error = "Divert"
+error_tries = 3
+divert_type = "#tmpname-dead-letter"
+divert_error_field = "dead_letter_reason"
+error_timeout = `)
+	// destinations read the same records, so oversized ones are spooled
+	// once, failed messages go to the spool of each destination
+	assert.Equal(t, strings.Count(b.String(), "[FileOutput_"), 4)
+	assert.Equal(t, strings.Count(b.String(), "[Decoder_#tmpname_oversize]"),
+		1)
+	assert.Contains(t, b.String(), `
+[Encoder_#tmpname-dead-letter]
+type = "KafkalogEncoder"
+
+[Encoder_#tmpname-dead-letter_reason]
+type = "SandboxEncoder"
+filename = "kafkafeeder/dead_letter_reason.lua"
+
+[FileOutput_#tmpname-dead-letter]
+type = "FileOutput"
+message_matcher = "Type == '#tmpname-dead-letter'"
+path = "/var/dead-letter/kafka/topic/%Y%m%d_%H%M%S_0_UTC-#tmpname.szn"
+rotation_interval = 1
+encoder = "Encoder_#tmpname-dead-letter"
+
+[FileOutput_#tmpname-dead-letter_reason]
+type = "FileOutput"
+message_matcher = "Type == '#tmpname-dead-letter'"
+path = "/var/dead-letter/kafka/topic/%Y%m%d_%H%M%S_0_UTC-#tmpname.reason"
+rotation_interval = 1
+encoder = "Encoder_#tmpname-dead-letter_reason"
+
+[SandboxOutput_#tmpname-dead-letter]
+type = "SandboxOutput"
+filename = "kafkafeeder/count.lua"
+message_matcher = "Type == '#tmpname-dead-letter'"
+`)
+	// oversized records are spooled as read, the others are decoded
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_oversize", "Decoder_#tmpname_log"]
+cascade_strategy = "first-wins"
+
+[Decoder_#tmpname_oversize]
+type = "SandboxDecoder"
+filename = "kafkafeeder/oversize.lua"
+output_limit = 2162688
+[Decoder_#tmpname_oversize.config]
+type = "#tmpname-dead-letter"
+max_size = 1048576
+
+[Decoder_#tmpname_log]
+type = "KafkalogDecoder"
+msg_type = "#tmpname"
+
+[Encoder_#tmpname]
+`)
+	assert.Contains(t, b.String(), `
+type = "KafkalogSplitter"
+keep_truncated = true
+`)
+}
+
+func TestConvertOversize(t *testing.T) {
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		DeadLetterDir: "/var/dead-letter",
+	})
+	assert.Nil(t, err)
+	// the size is checked on records as they were read, decoders of all
+	// log types get only the records which were not truncated
+	for logType, options := range map[string]interface{}{
+		"kafkalog":  nil,
+		"plaintext": &plaintextOptions{},
+		"jsonlines": &jsonlinesOptions{},
+		"syslog":    &syslogOptions{Payload: "json"},
+		"multiline": &multilineOptions{Start: `^\d`, maxSize: 65536},
+		"custom": &customOptions{Splitter: "line", Decoder: "json",
+			fileOptions: fileOptions{FileMatch: `name\.log`}},
+		"lua": &luaOptions{Script: "nginx_access.lua"},
+	} {
+		cfg := TopicConfig{
+			Topic:   "topic",
+			Type:    logType,
+			Broker:  "kafka",
+			Ack:     ACK_DISK_WRITE,
+			Options: options,
+		}
+		var b bytes.Buffer
+		err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+		assert.Nil(t, err, logType)
+		assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_oversize", "Decoder_#tmpname_log"]
+cascade_strategy = "first-wins"
+`, logType)
+
+		// static fields and redaction are chained after the log decoder
+		cfg.Fields = map[string]string{"dc": "ko"}
+		cfg.Redact = []RedactRule{{Detector: REDACT_EMAIL, Field: "Payload",
+			Strategy: REDACT_MASK}}
+		b.Reset()
+		err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+		assert.Nil(t, err, logType)
+		assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_oversize", "Decoder_#tmpname_decode"]
+cascade_strategy = "first-wins"
+`, logType)
+		assert.Contains(t, b.String(), `
+[Decoder_#tmpname_decode]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_log", "Decoder_#tmpname_fields", "Decoder_#tmpname_redact"]
+cascade_strategy = "all"
+`, logType)
+	}
+}
+
+func TestConvertOnError(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:   "topic",
+		Type:    "kafkalog",
+		Broker:  "kafka",
+		Ack:     ACK_DISK_WRITE,
+		OnError: OnError{Action: ON_ERROR_DROP, Tries: 3},
+		Destinations: []Destination{
+			{Topic: "copy", Broker: "kafka", Ack: ACK_DISK_WRITE},
+		},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		DeadLetterDir: "/var/dead-letter",
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	// dropped messages are diverted into counters of the destinations
+	assert.Contains(t, b.String(), `
+on_error = "Divert"
+error_tries = 3
+divert_type = "#tmpname@1-dropped"
+divert_error_field = "dead_letter_reason"
+error_timeout = `)
+	assert.Contains(t, b.String(), `
+[SandboxOutput_#tmpname-dropped]
+type = "SandboxOutput"
+filename = "kafkafeeder/count.lua"
+message_matcher = "Type == '#tmpname-dropped'"
+`)
+	assert.Equal(t, strings.Count(b.String(), "[FileOutput_"), 2)
+
+	cfg.OnError.Action = ON_ERROR_DIVERT
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+on_error = "Divert"
+error_tries = 3
+divert_type = "#tmpname-dead-letter"
+divert_error_field = "dead_letter_reason"
+error_timeout = `)
+	// every destination spools into the spool of its topic, oversized
+	// records are spooled only once
+	assert.Contains(t, b.String(), `
+[FileOutput_#tmpname@1-dead-letter]
+type = "FileOutput"
+message_matcher = "Type == '#tmpname@1-dead-letter'"
+path = "/var/dead-letter/kafka/copy/%Y%m%d_%H%M%S_0_UTC-#tmpname@1.szn"
+`)
+	assert.Equal(t, strings.Count(b.String(), "[FileOutput_"), 4)
+	assert.Equal(t, strings.Count(b.String(), "[Decoder_#tmpname_oversize]"),
+		1)
+
+	// topics with a spool divert by default, retry has to be explicit
+	cfg.OnError = OnError{}
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(b.String(), `on_error = "Divert"`), 2)
+	cfg.OnError = OnError{Action: ON_ERROR_RETRY}
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(b.String(), `on_error = "Retry"`), 2)
+
+	// without a spool messages are retried
+	c, err = NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	cfg.OnError = OnError{}
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(b.String(), `on_error = "Retry"`), 2)
+}
+
+func TestConvertDestinations(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Destinations: []Destination{
+			{Topic: "topic", Broker: "kafka_new", Ack: ACK_MEMORY_WRITE},
+		},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka":     []string{"kafka1.dev:9092"},
+			"kafka_new": []string{"kafka1.new:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[KafkaOutput_#tmpname@1]
+type = "KafkaOutput"
+message_matcher = "Type == '#tmpname'"
+encoder = "Encoder_#tmpname"
+addrs = ["kafka1.new:9092"]
+partitioner = "Hash"
+hash_variable = "Fields[key]"
+topic = "topic"
+required_acks = "WaitForLocal"
+on_error = "Retry"
+error_tries = 0
+error_timeout = 10000
+create_checkpoints = true
+checkpoint_name = "LogstreamerInput_#tmpname@1"
+`)
+	// the main output keeps its checkpoint and the log is read once
+	assert.Equal(t, strings.Count(b.String(), "checkpoint_name"), 1)
+	assert.Equal(t, strings.Count(b.String(), "[LogstreamerInput_"), 1)
+	assert.Equal(t, strings.Count(b.String(), "[KafkaOutput_"), 2)
+}
+
+func TestConvertRoutes(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Routes: []Route{{
+			Match:  `Fields[level] == "ERROR"`,
+			Topic:  "errors",
+			Broker: "kafka",
+			Ack:    ACK_MEMORY_WRITE,
+		}, {
+			Match:      "TRUE",
+			TopicField: "tenant",
+			Broker:     "kafka",
+			Ack:        ACK_DISK_WRITE,
+		}},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[KafkaOutput_#tmpname@route1]
+type = "KafkaOutput"
+message_matcher = "Type == '#tmpname' && (Fields[level] == \"ERROR\")"
+encoder = "Encoder_#tmpname"
+addrs = ["kafka1.dev:9092"]
+partitioner = "Hash"
+hash_variable = "Fields[key]"
+topic = "errors"
+required_acks = "WaitForLocal"
+on_error = "Retry"
+error_tries = 0
+error_timeout = 10000
+create_checkpoints = false
+`)
+	assert.Contains(t, b.String(), `
+[KafkaOutput_#tmpname@route2]
+type = "KafkaOutput"
+message_matcher = "Type == '#tmpname' && (TRUE) && Fields[tenant] != NIL"
+encoder = "Encoder_#tmpname"
+addrs = ["kafka1.dev:9092"]
+partitioner = "Hash"
+hash_variable = "Fields[key]"
+topic_variable = "Fields[tenant]"
+required_acks = "WaitForAll"
+`)
+
+	// records of tenants are not spooled into the spool of the main topic
+	c, err = NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		DeadLetterDir: "/var/dead-letter",
+	})
+	assert.Nil(t, err)
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.NotContains(t, b.String(), "dead-letter")
+	assert.NotContains(t, b.String(), "keep_truncated")
+
+	cfg.Routes = cfg.Routes[:1]
+	b.Reset()
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), "[FileOutput_#tmpname-dead-letter]")
+}
+
+func TestConvertFilter(t *testing.T) {
+	sent, filtered, sampled, err := filterMatchers("id", Filter{})
+	assert.Nil(t, err)
+	assert.Equal(t, sent, "Type == 'id'")
+	assert.Equal(t, filtered, "")
+	assert.Equal(t, sampled, "")
+
+	sent, filtered, sampled, err = filterMatchers("id", Filter{Sample: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, sent, "Type == 'id'")
+	assert.Equal(t, sampled, "")
+
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Filter: Filter{
+			Include: "Severity < 7",
+			Exclude: "Fields[level] == 'DEBUG'",
+			Sample:  25,
+		},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), "\nmessage_matcher = \"Type == '#tmpname'"+
+		" && (Severity < 7) && (Fields[level] != 'DEBUG' || Fields[level] =="+
+		" NIL) && Uuid < '4000'\"\n")
+	assert.Contains(t, b.String(), `
+[SandboxOutput_#tmpname-filtered]
+type = "SandboxOutput"
+filename = "kafkafeeder/count.lua"
+message_matcher = "Type == '#tmpname' && (Severity >= 7 || (Fields[level] == 'DEBUG'))"
+
+[SandboxOutput_#tmpname-sampled]
+type = "SandboxOutput"
+filename = "kafkafeeder/count.lua"
+message_matcher = "Type == '#tmpname' && (Severity < 7) && (Fields[level] != 'DEBUG' || Fields[level] == NIL) && Uuid >= '4000'"
+`)
+}
+
+func TestConvertRedact(t *testing.T) {
+	cfg := TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Redact: []RedactRule{
+			{Detector: REDACT_EMAIL, Field: "Payload", Strategy: REDACT_MASK},
+		},
+	}
+	c, err := NewConverter(&HekadConfig{
+		KafkaBrokers: map[string][]string{
+			"kafka": []string{"kafka1.dev:9092"},
+		},
+		Redact: []RedactRule{
+			{Detector: REDACT_CARD, Field: "Payload", Strategy: REDACT_HASH},
+		},
+		RedactSalt: "salt",
+	})
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	err = c.ConvertTopic("name", "/tmp", &cfg, &b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname]
+type = "MultiDecoder"
+subs = ["Decoder_#tmpname_log", "Decoder_#tmpname_redact"]
+cascade_strategy = "all"
+`)
+	// mandatory rules of hekad are applied first
+	assert.Contains(t, b.String(), `
+[Decoder_#tmpname_redact]
+type = "SandboxDecoder"
+filename = "kafkafeeder/redact.lua"
+output_limit = 2162688
+[Decoder_#tmpname_redact.config]
+rules = "[{\"detector\":\"card\",\"field\":\"Payload\",\"strategy\":\"hash\"},{\"detector\":\"email\",\"field\":\"Payload\",\"strategy\":\"mask\"}]"
+salt = "salt"
 `)
 }
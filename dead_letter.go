@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// deadLetterSuffix is suffix of type of diverted messages
+const deadLetterSuffix = "-dead-letter"
+
+// deadLetterRotation is how often hekad starts new dead-letter files, older
+// files are not written anymore
+const deadLetterRotation = time.Hour
+
+// deadLetterFileMatch matches dead-letter files of all log streams in spool
+const deadLetterFileMatch = `(?P<Date>\d+)_(?P<Time>\d+)_\d+_UTC-` +
+	`(?P<Id>[^/]+)\.szn`
+
+// DeadLetterSpool returns directory of dead-letter files of topic in broker
+func DeadLetterSpool(deadLetterDir, broker, topic string) string {
+	return filepath.Join(deadLetterDir, IdFromString(broker), topic)
+}
+
+// deadLetterFile returns name of dead-letter files of log stream id, it is
+// formatted by hekad with time of rotation in the same way as names of
+// kafkalog files
+func deadLetterFile(id string) string {
+	return "%Y%m%d_%H%M%S_0_UTC-" + id + ".szn"
+}
+
+// DeadLetterReasons returns path of sidecar file with reasons of records in
+// dead-letter file path, one line per record
+func DeadLetterReasons(path string) string {
+	return strings.TrimSuffix(path, ".szn") + ".reason"
+}
+
+// DeadLetters are dead-letter files of one topic
+type DeadLetters struct {
+	Broker string
+	Topic  string
+	Dir    string
+	Files  []*LogFile // from the oldest
+}
+
+// ListDeadLetters returns dead-letter spools of all brokers in deadLetterDir
+// which are not empty
+func ListDeadLetters(deadLetterDir string, brokers map[string][]string) (
+	[]*DeadLetters, error) {
+
+	names := make([]string, 0, len(brokers))
+	for broker := range brokers {
+		names = append(names, broker)
+	}
+	sort.Strings(names)
+	var spools []*DeadLetters
+	for _, broker := range names {
+		dir := filepath.Join(deadLetterDir, IdFromString(broker))
+		topics, err := readDirNames(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range topics {
+			spool := &DeadLetters{
+				Broker: broker,
+				Topic:  topic,
+				Dir:    filepath.Join(dir, topic),
+			}
+			spool.Files, err = ListLogFiles(spool.Dir, deadLetterFileMatch,
+				[]string{"Date", "Time"})
+			if err != nil {
+				return nil, err
+			}
+			if len(spool.Files) > 0 {
+				spools = append(spools, spool)
+			}
+		}
+	}
+	return spools, nil
+}
+
+// readDirNames returns sorted names of directories in dir, there are none
+// when dir does not exist
+func readDirNames(dir string) ([]string, error) {
+	file, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeadLetterReason is a reason why records were not delivered
+type DeadLetterReason struct {
+	Reason      string
+	Count       int64
+	First, Last time.Time
+}
+
+// Reasons returns reasons of all records in the spool from the most common
+func (d *DeadLetters) Reasons() ([]*DeadLetterReason, error) {
+	reasons := make(map[string]*DeadLetterReason)
+	for _, file := range d.Files {
+		err := readReasons(DeadLetterReasons(file.Path),
+			func(at time.Time, reason string) {
+				r, ok := reasons[reason]
+				if !ok {
+					r = &DeadLetterReason{Reason: reason, First: at}
+					reasons[reason] = r
+				}
+				r.Count++
+				if at.Before(r.First) {
+					r.First = at
+				}
+				if at.After(r.Last) {
+					r.Last = at
+				}
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sorted := make([]*DeadLetterReason, 0, len(reasons))
+	for _, r := range reasons {
+		sorted = append(sorted, r)
+	}
+	sort.Sort(deadLetterReasonSorter(sorted))
+	return sorted, nil
+}
+
+type deadLetterReasonSorter []*DeadLetterReason
+
+func (s deadLetterReasonSorter) Len() int      { return len(s) }
+func (s deadLetterReasonSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s deadLetterReasonSorter) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Reason < s[j].Reason
+}
+
+// readReasons calls fn for every line of reasons file path, lines are
+// written by dead_letter_reason.lua as "<RFC 3339 time>\t<reason>"
+func readReasons(path string, fn func(at time.Time, reason string)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		at, _ := time.Parse(time.RFC3339, parts[0])
+		fn(at, parts[1])
+	}
+	return scanner.Err()
+}
+
+// Records returns number of records in the spool
+func (d *DeadLetters) Records() (count int64, err error) {
+	for _, file := range d.Files {
+		err = readReasons(DeadLetterReasons(file.Path),
+			func(time.Time, string) { count++ })
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Settled returns files which hekad does not write anymore
+func (d *DeadLetters) Settled(now time.Time) []*LogFile {
+	var files []*LogFile
+	for _, file := range d.Files {
+		if file.Info.ModTime().Before(now.Add(-deadLetterRotation)) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Reinject sends settled records of the spool written until to again to
+// target topic by a one-off hekad. Files are removed only when Kafka
+// acknowledged all records of their log stream, files which hekad may still
+// write are skipped.
+func (d *DeadLetters) Reinject(lgr LOGGER, cfg *HekadConfig, target string,
+	to time.Time, stop <-chan struct{}) (files int, err error) {
+
+	var settled []*LogFile
+	for _, file := range d.Settled(time.Now()) {
+		if !file.Info.ModTime().After(to) {
+			settled = append(settled, file)
+		}
+	}
+	if skipped := len(d.Files) - len(settled); skipped > 0 {
+		lgr.Infof("Skipping %d files of %s/%s written in the last %s or "+
+			"after %s", skipped, d.Broker, d.Topic, deadLetterRotation,
+			to.Format(time.RFC3339))
+	}
+	if len(settled) == 0 {
+		return 0, nil
+	}
+	pipeline, err := NewOneOffPipeline(lgr, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer pipeline.Close()
+	streams := make(map[string][]*LogFile)
+	var ids []string
+	for _, file := range settled {
+		id := file.groups["Id"]
+		if _, ok := streams[id]; !ok {
+			ids = append(ids, id)
+		}
+		streams[id] = append(streams[id], file)
+	}
+	for _, id := range ids {
+		err = pipeline.AddStream(id, d.Dir, streams[id], &TopicConfig{
+			Topic:         target,
+			Type:          "kafkalog",
+			Broker:        d.Broker,
+			Retention:     -1,
+			RetentionSize: -1,
+			Ack:           ACK_DISK_WRITE,
+			Encoding:      ENCODING_RAW,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	// an interrupted run still removes streams which were delivered
+	err = pipeline.Run(stop)
+	for i, shipped := range pipeline.Shipped() {
+		if !shipped {
+			continue
+		}
+		for _, file := range streams[ids[i]] {
+			for _, path := range []string{file.Path,
+				DeadLetterReasons(file.Path)} {
+				if err := os.Remove(path); err != nil &&
+					!os.IsNotExist(err) {
+					return files, err
+				}
+			}
+			files++
+		}
+	}
+	return files, err
+}
+
+func WriteDeadLetters(wr io.Writer, spools []*DeadLetters) error {
+	tw := tabwriter.NewWriter(wr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BROKER\tTOPIC\tFILES\tRECORDS\tBYTES\tOLDEST\tNEWEST")
+	for _, spool := range spools {
+		records, err := spool.Records()
+		if err != nil {
+			return err
+		}
+		var size int64
+		oldest, newest := time.Time{}, time.Time{}
+		for _, file := range spool.Files {
+			size += file.Info.Size()
+			if oldest.IsZero() || file.Info.ModTime().Before(oldest) {
+				oldest = file.Info.ModTime()
+			}
+			if file.Info.ModTime().After(newest) {
+				newest = file.Info.ModTime()
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", spool.Broker,
+			spool.Topic, len(spool.Files), records, size,
+			oldest.Format(time.RFC3339), newest.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func WriteDeadLetterReasons(wr io.Writer, reasons []*DeadLetterReason) error {
+	tw := tabwriter.NewWriter(wr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COUNT\tFIRST\tLAST\tREASON")
+	for _, r := range reasons {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.Count,
+			r.First.Format(time.RFC3339), r.Last.Format(time.RFC3339),
+			r.Reason)
+	}
+	return tw.Flush()
+}
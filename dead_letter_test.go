@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	spoolDir := DeadLetterSpool(dir, "kafka", "events")
+	assert.Nil(t, os.MkdirAll(spoolDir, 0755))
+	assert.Nil(t, os.MkdirAll(DeadLetterSpool(dir, "kafka", "empty"), 0755))
+
+	writeTestLog(t, spoolDir, "20160101_100000_0_UTC-#a.szn", 10, 3*time.Hour)
+	writeTestLog(t, spoolDir, "20160101_120000_0_UTC-#a.szn", 10, 0)
+	assert.Nil(t, ioutil.WriteFile(
+		filepath.Join(spoolDir, "20160101_100000_0_UTC-#a.reason"),
+		[]byte("2016-01-01T10:00:00Z\tMessage too large\n"+
+			"2016-01-01T10:30:00Z\tNot authorized\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(
+		filepath.Join(spoolDir, "20160101_120000_0_UTC-#a.reason"),
+		[]byte("2016-01-01T12:00:00Z\tMessage too large\n"), 0644))
+
+	spools, err := ListDeadLetters(dir, map[string][]string{
+		"kafka":     []string{"kafka1:9092"},
+		"kafka_dev": []string{"kafka1.dev:9092"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(spools), 1)
+	spool := spools[0]
+	assert.Equal(t, spool.Broker, "kafka")
+	assert.Equal(t, spool.Topic, "events")
+	assert.Equal(t, len(spool.Files), 2)
+
+	records, err := spool.Records()
+	assert.Nil(t, err)
+	assert.Equal(t, records, int64(3))
+	reasons, err := spool.Reasons()
+	assert.Nil(t, err)
+	assert.Equal(t, reasons, []*DeadLetterReason{{
+		Reason: "Message too large",
+		Count:  2,
+		First:  time.Date(2016, 1, 1, 10, 0, 0, 0, time.UTC),
+		Last:   time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC),
+	}, {
+		Reason: "Not authorized",
+		Count:  1,
+		First:  time.Date(2016, 1, 1, 10, 30, 0, 0, time.UTC),
+		Last:   time.Date(2016, 1, 1, 10, 30, 0, 0, time.UTC),
+	}})
+
+	settled := spool.Settled(time.Now())
+	assert.Equal(t, len(settled), 1)
+	assert.Equal(t, settled[0].groups["Id"], "#a")
+}
+
+func TestReinjectKeepsUndelivered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	spoolDir := DeadLetterSpool(dir, "kafka", "events")
+	assert.Nil(t, os.MkdirAll(spoolDir, 0755))
+	writeTestLog(t, spoolDir, "20160101_100000_0_UTC-#a.szn", 10, 3*time.Hour)
+	spools, err := ListDeadLetters(dir, map[string][]string{
+		"kafka": []string{"kafka1:9092"},
+	})
+	assert.Nil(t, err)
+
+	// hekad exits before it delivers anything
+	files, err := spools[0].Reinject(logrus.New(), &HekadConfig{
+		BinPath:      "true",
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	}, "events", time.Now(), make(chan struct{}))
+	assert.NotNil(t, err)
+	assert.Equal(t, files, 0)
+	_, err = os.Stat(spools[0].Files[0].Path)
+	assert.Nil(t, err)
+}
+
+func TestOneOffPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeTestLog(t, dir, "20160101_000000_1_UTC-name.szn", 10, time.Hour)
+	writeTestLog(t, dir, "20160102_000000_1_UTC-name.szn", 20, 0)
+	files, err := ListLogFiles(dir, `\d+_\d+_\d+_UTC-name\.szn`, nil)
+	assert.Nil(t, err)
+
+	pipeline, err := NewOneOffPipeline(logrus.New(), &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	})
+	assert.Nil(t, err)
+	defer pipeline.Close()
+	err = pipeline.AddStream("name", dir, files, &TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+		Filter: Filter{Exclude: "Severity > 6", Sample: 10},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(pipeline.streams), 1)
+	stream := pipeline.streams[0]
+	target, err := os.Readlink(stream.links[1])
+	assert.Nil(t, err)
+	assert.Equal(t, target, files[1].Path)
+	conf, err := ioutil.ReadFile(filepath.Join(pipeline.dir, "conf",
+		stream.id+".toml"))
+	assert.Nil(t, err)
+	// filtered messages would stop checkpoints before the end
+	assert.Contains(t, string(conf),
+		fmt.Sprintf("message_matcher = \"Type == '%s'\"\n", stream.id))
+	assert.NotContains(t, string(conf), "SandboxOutput")
+
+	checkpointDir := filepath.Join(pipeline.dir, "cache", "checkpoint")
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+	writeCheckpoint := func(seek int) {
+		assert.Nil(t, ioutil.WriteFile(
+			filepath.Join(checkpointDir, JournalName(stream.id)),
+			[]byte(fmt.Sprintf(`{"seek":%d,"file_name":%q,"last_hash":""}`,
+				seek, stream.links[1])), 0644))
+	}
+	assert.False(t, pipeline.shipped())
+	writeCheckpoint(15)
+	assert.False(t, pipeline.shipped())
+	writeCheckpoint(20)
+	assert.True(t, pipeline.shipped())
+}
+
+func TestOneOffPipelineTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeTestLog(t, dir, "20160101_000000_1_UTC-name.szn", 10, time.Hour)
+	files, err := ListLogFiles(dir, `\d+_\d+_\d+_UTC-name\.szn`, nil)
+	assert.Nil(t, err)
+	// hekad which never delivers anything
+	bin := filepath.Join(dir, "hekad")
+	assert.Nil(t, ioutil.WriteFile(bin, []byte("#!/bin/sh\nexec sleep 60\n"),
+		0755))
+
+	pipeline, err := NewOneOffPipeline(logrus.New(), &HekadConfig{
+		BinPath:       bin,
+		KafkaBrokers:  map[string][]string{"kafka": []string{"kafka1:9092"}},
+		OneOffTimeout: time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer pipeline.Close()
+	assert.Nil(t, pipeline.AddStream("name", dir, files, &TopicConfig{
+		Topic:  "topic",
+		Type:   "kafkalog",
+		Broker: "kafka",
+		Ack:    ACK_DISK_WRITE,
+	}))
+	start := time.Now()
+	assert.EqualError(t, pipeline.Run(make(chan struct{})),
+		"Nothing was delivered for 1ms, hekad was stopped")
+	assert.True(t, time.Since(start) < 10*time.Second)
+	assert.Equal(t, pipeline.Shipped(), []bool{false})
+}
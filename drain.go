@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// DrainResult is what was shipped of a log stream by drain, also when it was
+// not shipped completely
+type DrainResult struct {
+	Path    string // kafkafeeder.yaml
+	Name    string
+	Broker  string
+	Topic   string
+	Files   int   // files delivered as a whole
+	Bytes   int64 // decompressed for gzipped files
+	Shipped bool  // all files were delivered to all destinations
+
+	dir   string
+	cfg   *TopicConfig
+	files []*LogFile
+	start *Journal
+}
+
+// leastCheckpoint returns the least advanced of existing checkpoints of all
+// destinations of log stream id
+func leastCheckpoint(checkpointDir, id string, destinations int) (
+	least *Journal, err error) {
+
+	for i := 0; i <= destinations; i++ {
+		checkpoint, err := ReadJournal(filepath.Join(checkpointDir,
+			CheckpointName(id, i)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if least == nil || LessAdvanced(checkpoint, least) {
+			least = checkpoint
+		}
+	}
+	return least, nil
+}
+
+// unshipped returns files of log stream name in dir which were not shipped
+// yet, and journal in the first of them to start from
+func unshipped(cfg *Config, dir, name string, topicCfg *TopicConfig,
+	now time.Time) ([]*LogFile, *Journal, error) {
+
+	_, err := SeedJournal(cfg.JournalDir, cfg.CheckpointDir, dir, name,
+		topicCfg, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := ListStreamFiles(dir, name, topicCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	start, err := leastCheckpoint(cfg.CheckpointDir, StreamId(dir, name),
+		len(topicCfg.Destinations))
+	if err != nil || start == nil {
+		return files, nil, err
+	}
+	idx := FileIndex(files, start.FileName)
+	if idx < 0 {
+		// the checkpointed file is gone, ship all remaining ones
+		return files, nil, nil
+	}
+	files = files[idx:]
+	length, err := readLength(files[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if start.Seek >= length {
+		return files[1:], nil, nil
+	}
+	return files, start, nil
+}
+
+// Drain ships everything of all kafkafeeders of logManager which was not
+// shipped yet by one one-off pipeline. Checkpoints of shipped log streams are
+// advanced and their files recorded in ledgers. Results are returned also
+// when shipping fails.
+func Drain(lgr LOGGER, cfg *Config, logManager *LogManager,
+	stop <-chan struct{}) ([]*DrainResult, error) {
+
+	var (
+		results []*DrainResult
+		err     error
+		now     = time.Now()
+	)
+	logManager.Each(func(path string, log *LogConfig) {
+		for name, topicCfg := range log.Topics {
+			if err != nil {
+				return
+			}
+			r := &DrainResult{
+				Path:   path,
+				Name:   name,
+				Broker: topicCfg.Broker,
+				Topic:  topicCfg.Topic,
+				dir:    log.Directory,
+				cfg:    topicCfg,
+			}
+			r.files, r.start, err = unshipped(cfg, log.Directory, name,
+				topicCfg, now)
+			if err != nil {
+				err = fmt.Errorf("Error listing files of %q in %q: %v",
+					name, log.Directory, err)
+				return
+			}
+			results = append(results, r)
+		}
+	})
+	sort.Sort(drainResultSorter(results))
+	if err != nil {
+		return results, err
+	}
+
+	pipeline, err := NewOneOffPipeline(lgr, &cfg.Hekad)
+	if err != nil {
+		return results, err
+	}
+	defer pipeline.Close()
+	var added []*DrainResult
+	for _, r := range results {
+		if len(r.files) == 0 {
+			r.Shipped = true
+			continue
+		}
+		err = pipeline.AddStreamFrom(r.Name, r.dir, r.files, r.cfg, r.start)
+		if err != nil {
+			return results, err
+		}
+		added = append(added, r)
+	}
+	runErr := pipeline.Run(stop)
+	for i, progress := range pipeline.Progress() {
+		r := added[i]
+		r.Files = len(progress.Files)
+		r.Bytes = progress.Bytes
+		if r.Shipped = r.Files == len(r.files); !r.Shipped {
+			continue
+		}
+		if err = r.advanceCheckpoints(cfg.CheckpointDir); err != nil {
+			return results, err
+		}
+		if cfg.LedgerDir == "" {
+			continue
+		}
+		// the checkpointed file can still grow, only files below the least
+		// checkpoint are recorded like by the cleaner
+		_, err = UpdateLedger(cfg.LedgerDir, cfg.CheckpointDir, r.dir,
+			r.Name, r.cfg, now)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, runErr
+}
+
+// advanceCheckpoints sets checkpoints of all destinations at the end of the
+// shipped files
+func (r *DrainResult) advanceCheckpoints(checkpointDir string) error {
+	last := r.files[len(r.files)-1]
+	journal, err := fileJournal(last.Path, last.Info.Size())
+	if err != nil {
+		return err
+	}
+	id := StreamId(r.dir, r.Name)
+	for i := 0; i <= len(r.cfg.Destinations); i++ {
+		if err = writeJournal(filepath.Join(checkpointDir,
+			CheckpointName(id, i)), journal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drained reports whether everything was shipped
+func Drained(results []*DrainResult) bool {
+	for _, r := range results {
+		if !r.Shipped {
+			return false
+		}
+	}
+	return true
+}
+
+type drainResultSorter []*DrainResult
+
+func (s drainResultSorter) Len() int      { return len(s) }
+func (s drainResultSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s drainResultSorter) Less(i, j int) bool {
+	if s[i].Path != s[j].Path {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Name < s[j].Name
+}
+
+func WriteDrainResults(wr io.Writer, results []*DrainResult) error {
+	tw := tabwriter.NewWriter(wr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KAFKAFEEDER\tNAME\tBROKER\tTOPIC\tFILES\tBYTES\tSHIPPED")
+	for _, r := range results {
+		shipped := "yes"
+		if !r.Shipped {
+			shipped = "no"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", r.Path, r.Name,
+			r.Broker, r.Topic, r.Files, r.Bytes, shipped)
+	}
+	return tw.Flush()
+}
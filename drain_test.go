@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainUnshipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &Config{
+		LogDir:        filepath.Join(dir, "logs"),
+		JournalDir:    filepath.Join(dir, "logstreamer"),
+		CheckpointDir: filepath.Join(dir, "checkpoint"),
+		Hekad: HekadConfig{
+			KafkaBrokers: map[string][]string{"kafka": {"kafka1:9092"}},
+		},
+	}
+	for _, d := range []string{cfg.LogDir, cfg.JournalDir, cfg.CheckpointDir} {
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	writeTestLog(t, cfg.LogDir, "20160101_000000_1_UTC-name.szn", 10,
+		2*time.Hour)
+	writeTestLog(t, cfg.LogDir, "20160102_000000_1_UTC-name.szn", 20,
+		time.Hour)
+	writeTestLog(t, cfg.LogDir, "20160103_000000_1_UTC-name.szn", 30, 0)
+	topicCfg := &TopicConfig{Topic: "topic", Type: "kafkalog",
+		Broker: "kafka", Ack: ACK_DISK_WRITE,
+		Destinations: []Destination{{Topic: "copy", Broker: "kafka"}}}
+	id := StreamId(cfg.LogDir, "name")
+	path := func(name string) string {
+		return filepath.Join(cfg.LogDir, name)
+	}
+
+	files, start, err := unshipped(cfg, cfg.LogDir, "name", topicCfg,
+		time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+	assert.Nil(t, start)
+
+	// the least advanced destination is shipped from
+	assert.Nil(t, writeJournal(filepath.Join(cfg.CheckpointDir,
+		CheckpointName(id, 0)), &Journal{Seek: 30,
+		FileName: path("20160103_000000_1_UTC-name.szn")}))
+	assert.Nil(t, writeJournal(filepath.Join(cfg.CheckpointDir,
+		CheckpointName(id, 1)), &Journal{Seek: 5,
+		FileName: path("20160102_000000_1_UTC-name.szn")}))
+	files, start, err = unshipped(cfg, cfg.LogDir, "name", topicCfg,
+		time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, int64(5), start.Seek)
+
+	result := &DrainResult{Name: "name", dir: cfg.LogDir, cfg: topicCfg,
+		files: files}
+	assert.Nil(t, result.advanceCheckpoints(cfg.CheckpointDir))
+	files, start, err = unshipped(cfg, cfg.LogDir, "name", topicCfg,
+		time.Now())
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+	assert.Nil(t, start)
+
+	// the one-off pipeline starts at the checkpoint
+	files, err = ListLogFiles(cfg.LogDir, `\d+_\d+_\d+_UTC-name\.szn`, nil)
+	assert.Nil(t, err)
+	pipeline, err := NewOneOffPipeline(logrus.New(), &cfg.Hekad)
+	assert.Nil(t, err)
+	defer pipeline.Close()
+	err = pipeline.AddStreamFrom("name", cfg.LogDir, files[1:], topicCfg,
+		&Journal{Seek: 5, FileName: files[1].Path})
+	assert.Nil(t, err)
+	stream := pipeline.streams[0]
+	journal, err := ReadJournal(filepath.Join(pipeline.dir, "cache",
+		"logstreamer", JournalName(stream.id)))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), journal.Seek)
+	target, err := os.Readlink(journal.FileName)
+	assert.Nil(t, err)
+	assert.Equal(t, files[1].Path, target)
+	assert.Equal(t, []bool{false}, pipeline.Shipped())
+
+	// progress is the least advanced checkpoint mapped to the real files
+	checkpointDir := filepath.Join(pipeline.dir, "cache", "checkpoint")
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+	assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+		CheckpointName(stream.id, 0)), &Journal{Seek: 10,
+		FileName: stream.links[1]}))
+	assert.Equal(t, []bool{false}, pipeline.Shipped())
+	assert.Equal(t, StreamProgress{}, pipeline.Progress()[0])
+	assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+		CheckpointName(stream.id, 1)), &Journal{Seek: 20,
+		FileName: stream.links[0]}))
+	progress := pipeline.Progress()
+	assert.Equal(t, files[1:2], progress[0].Files)
+	assert.Equal(t, int64(15), progress[0].Bytes)
+	for i := 0; i <= 1; i++ {
+		assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+			CheckpointName(stream.id, i)), &Journal{Seek: 30,
+			FileName: stream.links[1]}))
+	}
+	assert.Equal(t, []bool{true}, pipeline.Shipped())
+	assert.Equal(t, int64(45), pipeline.Progress()[0].Bytes)
+}
+
+func TestDrainGzipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &Config{
+		LogDir:        filepath.Join(dir, "logs"),
+		JournalDir:    filepath.Join(dir, "logstreamer"),
+		CheckpointDir: filepath.Join(dir, "checkpoint"),
+		Hekad: HekadConfig{
+			KafkaBrokers: map[string][]string{"kafka": {"kafka1:9092"}},
+		},
+	}
+	for _, d := range []string{cfg.LogDir, cfg.JournalDir, cfg.CheckpointDir} {
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err = gz.Write(bytes.Repeat([]byte("record\n"), 100))
+	assert.Nil(t, err)
+	assert.Nil(t, gz.Close())
+	rotated := filepath.Join(cfg.LogDir, "name.log.1.gz")
+	assert.Nil(t, ioutil.WriteFile(rotated, buf.Bytes(), 0644))
+	writeTestLog(t, cfg.LogDir, "name.log", 10, 0)
+	topicCfg := &TopicConfig{Topic: "topic", Type: "plaintext",
+		Broker: "kafka", Ack: ACK_DISK_WRITE,
+		Options: &plaintextOptions{fileOptions{Rotation: rotationLogrotate}}}
+
+	// logstreamer seeks in the decompressed file
+	assert.Nil(t, writeJournal(filepath.Join(cfg.CheckpointDir,
+		CheckpointName(StreamId(cfg.LogDir, "name"), 0)),
+		&Journal{Seek: 700, FileName: rotated}))
+	files, start, err := unshipped(cfg, cfg.LogDir, "name", topicCfg,
+		time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, filepath.Join(cfg.LogDir, "name.log"), files[0].Path)
+	assert.Nil(t, start)
+
+	files, err = ListStreamFiles(cfg.LogDir, "name", topicCfg)
+	assert.Nil(t, err)
+	pipeline, err := NewOneOffPipeline(logrus.New(), &cfg.Hekad)
+	assert.Nil(t, err)
+	defer pipeline.Close()
+	assert.Nil(t, pipeline.AddStream("name", cfg.LogDir, files, topicCfg))
+	stream := pipeline.streams[0]
+	assert.Equal(t, []int64{700, 710}, stream.ends)
+	checkpointDir := filepath.Join(pipeline.dir, "cache", "checkpoint")
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+	assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+		CheckpointName(stream.id, 0)), &Journal{Seek: 700,
+		FileName: stream.links[0]}))
+	progress := pipeline.Progress()
+	assert.Equal(t, files[:1], progress[0].Files)
+	assert.Equal(t, int64(700), progress[0].Bytes)
+}
@@ -0,0 +1,8 @@
+--[[
+Only counts matched messages, hekad reports the count as
+ProcessMessageCount of the output.
+--]]
+
+function process_message()
+    return 0
+end
@@ -0,0 +1,17 @@
+--[[
+Encodes reason of a dead letter as a line "<RFC 3339 time>\t<reason>", the
+lines are in the same order as records in the dead-letter file.
+--]]
+
+require "math"
+require "os"
+require "string"
+
+function process_message()
+    local reason = read_message("Fields[dead_letter_reason]") or "unknown"
+    reason = string.gsub(reason, "[\t\r\n]", " ")
+    local at = os.date("!%Y-%m-%dT%H:%M:%SZ",
+                       math.floor(read_message("Timestamp") / 1e9))
+    inject_payload("txt", "", at .. "\t" .. reason .. "\n")
+    return 0
+end
@@ -0,0 +1,42 @@
+--[[
+Encodes messages into JSON envelope:
+
+{"timestamp": <ms since epoch>, "host": "...", "stream": "...",
+ "source": "...", "payload": "...", "fields": {...}}
+
+The source is the path of the file the record was read from, logstreamer
+sets it to Fields[source_file].
+
+Config:
+
+- stream (string): log stream the messages come from
+--]]
+
+require "cjson"
+require "math"
+
+local stream = read_config("stream")
+
+function process_message()
+    local fields = {}
+    while true do
+        local typ, name, value = read_next_field()
+        if not typ then
+            break
+        end
+        if name ~= "source_file" then
+            fields[name] = value
+        end
+    end
+
+    local envelope = {
+        timestamp = math.floor(read_message("Timestamp") / 1e6),
+        host = read_message("Hostname"),
+        stream = stream,
+        source = read_message("Fields[source_file]"),
+        payload = read_message("Payload"),
+        fields = fields
+    }
+    inject_payload("json", "", cjson.encode(envelope))
+    return 0
+end
@@ -0,0 +1,53 @@
+--[[
+Decodes newline delimited JSON. The line is kept as the payload, value of
+key_field is stored into Fields[key] which is used for partitioning.
+
+Config:
+
+- type (string): type of decoded messages
+- key_field (string, optional): JSON field used as the message key
+- malformed_type (string, optional): type of messages with lines which are
+  not valid JSON objects. When not set, malformed lines are dropped and
+  counted as decoder failures.
+--]]
+
+require "cjson"
+
+local msg_type = read_config("type")
+local key_field = read_config("key_field")
+local malformed_type = read_config("malformed_type")
+
+local msg = {
+    Type = msg_type,
+    Payload = nil,
+    Fields = nil
+}
+
+local malformed = {
+    Type = malformed_type,
+    Payload = nil
+}
+
+function process_message()
+    local payload = read_message("Payload")
+    local ok, json = pcall(cjson.decode, payload)
+    if not ok or type(json) ~= "table" then
+        if not malformed_type then
+            return -1, "malformed JSON"
+        end
+        malformed.Payload = payload
+        inject_message(malformed)
+        return 0
+    end
+
+    msg.Payload = payload
+    msg.Fields = {}
+    if key_field then
+        local key = json[key_field]
+        if key ~= nil and type(key) ~= "table" then
+            msg.Fields.key = tostring(key)
+        end
+    end
+    inject_message(msg)
+    return 0
+end
@@ -0,0 +1,28 @@
+--[[
+Passes multiline records, records longer than max_size are truncated.
+
+Config:
+
+- type (string): type of decoded messages
+- max_size (int, optional): maximal size of a record in bytes
+--]]
+
+require "string"
+
+local msg_type = read_config("type")
+local max_size = read_config("max_size")
+
+local msg = {
+    Type = msg_type,
+    Payload = nil
+}
+
+function process_message()
+    local payload = read_message("Payload")
+    if max_size and #payload > max_size then
+        payload = string.sub(payload, 1, max_size)
+    end
+    msg.Payload = payload
+    inject_message(msg)
+    return 0
+end
@@ -0,0 +1,27 @@
+--[[
+Diverts records truncated by the splitter. Splitters keep truncated records
+which filled the whole buffer, so a payload of max_size bytes is marked as
+a dead letter instead of being sent to Kafka. The record is checked as it
+was read, before other decoders change it; smaller records fail, so that a
+first-wins MultiDecoder passes them to the decoder of the log type.
+
+Config:
+
+- type (string): type of diverted messages
+- max_size (int): max_message_size of hekad
+--]]
+
+local msg_type = read_config("type")
+local max_size = read_config("max_size")
+
+function process_message()
+    local payload = read_message("Payload")
+    if not payload or #payload < max_size then
+        return -1
+    end
+    write_message("Type", msg_type)
+    write_message("Fields[dead_letter_reason]",
+                  "record exceeds max_message_size " .. max_size ..
+                  " bytes and was truncated")
+    return 0
+end
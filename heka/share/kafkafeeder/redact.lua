@@ -0,0 +1,172 @@
+--[[
+Redacts sensitive data in the payload and in fields of messages.
+
+Rules are applied in order, each of them finds data either by a built-in
+detector (email, card, token) or by a Lua pattern and replaces it by its
+strategy:
+
+- mask: the data is replaced by [REDACTED]
+- hash: the data is replaced by a salted hash, equal data have equal hashes
+- drop: the whole value of the payload or the field is emptied, the field
+  is kept as decoders can not remove fields
+
+Config:
+
+- rules (string): JSON list of rules {"detector": "...", "pattern": "...",
+  "field": "Payload" or name of a field, "strategy": "..."}
+- salt (string): prepended to hashed data
+--]]
+
+require "cjson"
+require "math"
+require "string"
+require "table"
+
+local rules = cjson.decode(read_config("rules"))
+local salt = read_config("salt") or ""
+
+local MASK = "[REDACTED]"
+
+-- hash returns 64 bits of two polynomial hashes in hex, the sandbox has no
+-- cryptographic functions
+local function hash(data)
+    data = salt .. data
+    local a, b = 0, 0
+    for i = 1, #data do
+        local c = string.byte(data, i)
+        a = (a * 31 + c) % 4294967291
+        b = (b * 131 + c) % 4294967279
+    end
+    return string.format("%08x%08x", a, b)
+end
+
+-- luhn checks a card number
+local function luhn(digits)
+    local sum = 0
+    local double = false
+    for i = #digits, 1, -1 do
+        local d = string.byte(digits, i) - 48
+        if double then
+            d = d * 2
+            if d > 9 then
+                d = d - 9
+            end
+        end
+        sum = sum + d
+        double = not double
+    end
+    return sum % 10 == 0
+end
+
+-- tokens are kept with their prefixes, which say what the token is
+local token_patterns = {
+    "([Bb][Ee][Aa][Rr][Ee][Rr]%s+)([%w%-%._~%+/]+=*)",
+    "([Tt][Oo][Kk][Ee][Nn][\"']?%s*[=:]%s*[\"']?)([^%s&,;\"']+)",
+    "([Pp][Aa][Ss][Ss][Ww][Oo][Rr][Dd][\"']?%s*[=:]%s*[\"']?)([^%s&,;\"']+)",
+    "([Aa][Pp][Ii][_%-]?[Kk][Ee][Yy][\"']?%s*[=:]%s*[\"']?)([^%s&,;\"']+)",
+    "([Ss][Ee][Cc][Rr][Ee][Tt][\"']?%s*[=:]%s*[\"']?)([^%s&,;\"']+)",
+}
+
+local EMAIL = "[%w%._%%%+%-]+@[%w%.%-]+%.%a%a+"
+-- runs of digits, spaces and dashes which can contain card numbers
+local DIGITS = "%d[%d %-]*%d"
+
+-- is_card checks length and checksum of digits of a card number
+local function is_card(digits)
+    return #digits >= 13 and #digits <= 19 and luhn(digits)
+end
+
+-- redact_cards replaces card numbers in run of digits and separators by
+-- replace. Card numbers are either contiguous or in groups of four digits
+-- separated by a space or a dash, the longest valid number is replaced.
+local function redact_cards(run, replace)
+    local groups, seps = {}, {}
+    for group, sep in string.gmatch(run, "(%d+)([ %-]*)") do
+        groups[#groups + 1] = group
+        seps[#seps + 1] = sep
+    end
+    local parts = {}
+    local i = 1
+    while i <= #groups do
+        local last
+        if is_card(groups[i]) then
+            last = i
+        elseif #groups[i] == 4 then
+            local digits = groups[i]
+            for j = i + 1, math.min(#groups, i + 4) do
+                if #seps[j - 1] ~= 1 then
+                    break
+                end
+                digits = digits .. groups[j]
+                if is_card(digits) then
+                    last = j
+                end
+                if #groups[j] ~= 4 then
+                    break
+                end
+            end
+        end
+        if last then
+            local number = {}
+            for j = i, last - 1 do
+                number[#number + 1] = groups[j] .. seps[j]
+            end
+            number[#number + 1] = groups[last]
+            parts[#parts + 1] = replace(table.concat(number)) .. seps[last]
+            i = last + 1
+        else
+            parts[#parts + 1] = groups[i] .. seps[i]
+            i = i + 1
+        end
+    end
+    return table.concat(parts)
+end
+
+-- redact replaces data found by rule in value, found is true when there
+-- was any
+local function redact(rule, value)
+    local found = false
+    local replace = function(data)
+        found = true
+        if rule.strategy == "hash" then
+            return hash(data)
+        end
+        return MASK
+    end
+    if rule.detector == "email" then
+        value = string.gsub(value, EMAIL, replace)
+    elseif rule.detector == "card" then
+        value = string.gsub(value, DIGITS, function(run)
+            return redact_cards(run, replace)
+        end)
+    elseif rule.detector == "token" then
+        for _, pattern in ipairs(token_patterns) do
+            value = string.gsub(value, pattern, function(prefix, token)
+                return prefix .. replace(token)
+            end)
+        end
+    else
+        value = string.gsub(value, rule.pattern, replace)
+    end
+    return value, found
+end
+
+function process_message()
+    for _, rule in ipairs(rules) do
+        local name = rule.field
+        if name ~= "Payload" then
+            name = "Fields[" .. name .. "]"
+        end
+        local value = read_message(name)
+        if type(value) == "string" then
+            local redacted, found = redact(rule, value)
+            if found then
+                if rule.strategy == "drop" then
+                    redacted = ""
+                end
+                write_message(name, redacted)
+            end
+        end
+    end
+    return 0
+end
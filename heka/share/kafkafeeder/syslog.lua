@@ -0,0 +1,124 @@
+--[[
+Decodes syslog lines in RFC5424 or RFC3164 format (with or without the
+<PRI> part) into fields facility, severity, hostname, app, pid, msgid,
+timestamp and message. Lines which can not be parsed are dropped and counted
+as decoder failures.
+
+Config:
+
+- type (string): type of decoded messages
+- payload (string, optional): "raw" keeps the original line as the payload,
+  "json" replaces it with JSON encoded parsed fields. Default: raw
+--]]
+
+require "cjson"
+require "math"
+require "string"
+
+local msg_type = read_config("type")
+local json_payload = read_config("payload") == "json"
+
+local rfc5424 = "^<(%d+)>%d+ (%S+) (%S+) (%S+) (%S+) (%S+) (.*)$"
+local rfc3164 = "^(%a%a%a [ %d]%d %d%d:%d%d:%d%d) (%S+) (.*)$"
+local rfc3339 = "^(%d%d%d%d%-%d%d%-%d%dT%S+) (%S+) (.*)$"
+local tag = "^([^:%[%s]+)%[?(%d*)%]?: ?(.*)$"
+
+local function nil_value(value)
+    if value == "-" or value == "" then
+        return nil
+    end
+    return value
+end
+
+-- splits structured data from the rest of RFC5424 line
+local function structured_data(rest)
+    if string.sub(rest, 1, 1) == "-" then
+        return nil, string.sub(rest, 3)
+    end
+    local pos = 1
+    while string.sub(rest, pos, pos) == "[" do
+        local escaped = false
+        repeat
+            pos = pos + 1
+            local c = string.sub(rest, pos, pos)
+            if c == "" then
+                return nil, nil
+            end
+            local close = c == "]" and not escaped
+            escaped = c == "\\" and not escaped
+        until close
+        pos = pos + 1
+    end
+    if pos == 1 then
+        return nil, nil
+    end
+    return string.sub(rest, 1, pos - 1), string.sub(rest, pos + 1)
+end
+
+local function parse(line)
+    local fields = {}
+    local pri, timestamp, hostname, app, pid, msgid, rest =
+        string.match(line, rfc5424)
+    if pri then
+        fields.timestamp = nil_value(timestamp)
+        fields.hostname = nil_value(hostname)
+        fields.app = nil_value(app)
+        fields.pid = nil_value(pid)
+        fields.msgid = nil_value(msgid)
+        fields.structured_data, fields.message = structured_data(rest)
+        if not fields.message then
+            return nil
+        end
+    else
+        local header
+        pri, header = string.match(line, "^<(%d+)>(.*)$")
+        if not pri then
+            header = line
+        end
+        timestamp, hostname, rest = string.match(header, rfc3164)
+        if not timestamp then
+            timestamp, hostname, rest = string.match(header, rfc3339)
+            if not timestamp then
+                return nil
+            end
+        end
+        fields.timestamp = timestamp
+        fields.hostname = hostname
+        app, pid, fields.message = string.match(rest, tag)
+        if app then
+            fields.app = app
+            fields.pid = nil_value(pid)
+        else
+            fields.message = rest
+        end
+    end
+    if pri then
+        pri = tonumber(pri)
+        fields.facility = math.floor(pri / 8)
+        fields.severity = pri % 8
+    end
+    return fields
+end
+
+function process_message()
+    local payload = read_message("Payload")
+    local line = string.gsub(payload, "\r?\n$", "")
+    local fields = parse(line)
+    if not fields then
+        return -1, "not a syslog line"
+    end
+
+    local msg = {
+        Type = msg_type,
+        Hostname = fields.hostname,
+        Severity = fields.severity,
+        Pid = tonumber(fields.pid),
+        Payload = payload,
+        Fields = fields
+    }
+    if json_payload then
+        msg.Payload = cjson.encode(fields)
+    end
+    inject_message(msg)
+    return 0
+end
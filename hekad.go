@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type hekadOutputCatcher struct {
@@ -32,14 +33,18 @@ type HekadCmd struct {
 	cmd             *exec.Cmd
 	lgr             LOGGER
 	bin, cfg        string
+	throttle        *Throttle
 	ShouldBeRunning bool
 }
 
-func NewHekadCmd(lgr LOGGER, bin, cfg string) (*HekadCmd, error) {
+func NewHekadCmd(lgr LOGGER, bin, cfg string, throttle *Throttle) (
+	*HekadCmd, error) {
+
 	hekad := &HekadCmd{
 		lgr:             lgr,
 		bin:             bin,
 		cfg:             cfg,
+		throttle:        throttle,
 		ShouldBeRunning: false,
 	}
 	hekad.initCmd()
@@ -62,6 +67,8 @@ func (h *HekadCmd) Start() bool {
 	err := h.cmd.Start()
 	if err != nil {
 		h.lgr.Errorf("Error starting hekad process %q", err)
+	} else {
+		h.throttle.Start(h.cmd.Process.Pid)
 	}
 	h.ShouldBeRunning = true
 	return err == nil
@@ -69,6 +76,8 @@ func (h *HekadCmd) Start() bool {
 
 func (h *HekadCmd) Stop() {
 	h.ShouldBeRunning = false
+	// a paused hekad would not terminate
+	h.throttle.Stop()
 	h.lgr.Infof("Sending SIGTERM to hekad process")
 	err := h.cmd.Process.Signal(syscall.SIGTERM)
 	if err != nil {
@@ -129,29 +138,35 @@ type Hekad struct {
 	CallShutDown func()
 	converter    *Converter
 	hekad        *HekadCmd
+	// journalDir and checkpointDir are seeded for new log streams
+	journalDir    string
+	checkpointDir string
 }
 
 func NewHekad(lgr LOGGER, cfg *HekadConfig, logManager *LogManager,
-	shutdownChan chan struct{}, wg *sync.WaitGroup, shutDownFunc func()) (
-	*Hekad, error) {
+	journalDir, checkpointDir string, shutdownChan chan struct{},
+	wg *sync.WaitGroup, shutDownFunc func()) (*Hekad, error) {
 
-	converter, err := NewConverter(cfg.KafkaBrokers)
+	converter, err := NewConverter(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("Error initializing converter %q", err)
 	}
-	hekadCmd, err := NewHekadCmd(lgr, cfg.BinPath, cfg.ConfDir)
+	hekadCmd, err := NewHekadCmd(lgr, cfg.BinPath, cfg.ConfDir,
+		NewThrottle(lgr, cfg.Egress))
 	if err != nil {
 		return nil, fmt.Errorf("Error initializing hekaCmd %q", err)
 	}
 	hekad := &Hekad{
-		lgr:          lgr,
-		cfg:          cfg,
-		logManager:   logManager,
-		wg:           wg,
-		shutdownChan: shutdownChan,
-		CallShutDown: shutDownFunc,
-		converter:    converter,
-		hekad:        hekadCmd,
+		lgr:           lgr,
+		cfg:           cfg,
+		logManager:    logManager,
+		wg:            wg,
+		shutdownChan:  shutdownChan,
+		CallShutDown:  shutDownFunc,
+		converter:     converter,
+		hekad:         hekadCmd,
+		journalDir:    journalDir,
+		checkpointDir: checkpointDir,
 	}
 	if err = hekad.resetConf(); err != nil {
 		return nil, fmt.Errorf("Error prepareing conf dir %q", err)
@@ -172,7 +187,15 @@ func (h *Hekad) resetConf() error {
 	h.lgr.Infof("Conf dir %q clean", h.cfg.ConfDir)
 
 	err = os.Symlink(h.cfg.MainConfPath, h.cfg.ConfDir+"/hekad.toml")
-	return err
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(h.cfg.ConfDir, "dashboard.toml"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return h.converter.ConvertDashboard(file)
 }
 
 func (h *Hekad) Check() {
@@ -182,6 +205,24 @@ func (h *Hekad) Check() {
 	}
 }
 
+// seedJournals positions new log streams by their start_from before hekad
+// reads them for the first time
+func (h *Hekad) seedJournals(log *LogConfig) {
+	for name, cfg := range log.Topics {
+		journal, err := SeedJournal(h.journalDir, h.checkpointDir,
+			log.Directory, name, cfg, time.Now())
+		if err != nil {
+			h.lgr.Errorf("Error seeding journal of %q in %q: %q", name,
+				log.Directory, err)
+			continue
+		}
+		if journal != nil {
+			h.lgr.Infof("%q in %q starts after %q", name, log.Directory,
+				journal.FileName)
+		}
+	}
+}
+
 func (h *Hekad) Reload() {
 	h.lgr.Infof("Request to reload configuration accepted")
 	if err := h.resetConf(); err != nil {
@@ -189,29 +230,31 @@ func (h *Hekad) Reload() {
 		h.CallShutDown()
 		return
 	}
-	var (
-		file *os.File
-		err  error
-	)
-	for path, log := range h.logManager.Logs {
-		file, err = os.Create(filepath.Join(
+	h.logManager.Each(func(path string, log *LogConfig) {
+		h.seedJournals(log)
+		file, err := os.Create(filepath.Join(
 			h.cfg.ConfDir, IdFromString(path)+".toml"))
 		if err != nil {
 			h.lgr.Errorf("Error creating converted file %q", err)
+			return
 		}
+		defer file.Close()
 		err = h.converter.Convert(log, file)
 		if err != nil {
 			h.lgr.Errorf("Error converting file %q: %q", path, err)
 		}
-	}
+	})
 
 	if ok := h.hekad.Reload(); !ok {
 		h.lgr.Errorf("Error reloading hekad - shuting down")
 		h.CallShutDown()
 	} else {
 		h.lgr.Debugf("Loaded logs:")
-		for file, log := range h.logManager.Logs {
+		h.logManager.Each(func(file string, log *LogConfig) {
 			h.lgr.Debugf("%v => %v (%+v)", file, log.Directory, log.Topics)
+		})
+		for file, err := range h.logManager.Errors() {
+			h.lgr.Warnf("Rejected %v: %v", file, err)
 		}
 	}
 	return
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Journal is a position of the logstreamer in a stream of log files. Hekad
+// uses the same format both for its logstreamer journals and for checkpoints
+// of messages delivered to Kafka.
+type Journal struct {
+	Seek     int64  `json:"seek"`
+	FileName string `json:"file_name"`
+	LastHash string `json:"last_hash"`
+}
+
+// JournalName returns name of journal (and checkpoint) file of log stream id
+func JournalName(id string) string {
+	return "LogstreamerInput_" + id
+}
+
+func ReadJournal(path string) (*Journal, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	journal := &Journal{}
+	if err = json.Unmarshal(data, journal); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// destinationSeparator separates number of additional destination in names
+// of outputs and checkpoints, it can not be a part of stream id
+const destinationSeparator = "@"
+
+// CheckpointName returns name of checkpoint file of destination of log
+// stream id, the main destination is 0 and uses the journal name
+func CheckpointName(id string, destination int) string {
+	return JournalName(OutputId(id, destination))
+}
+
+// LeastCheckpoints returns paths of the least advanced checkpoints in
+// checkpointDir by names of journals they restore. Unreadable checkpoints
+// are skipped.
+func LeastCheckpoints(checkpointDir string, lgr LOGGER) (map[string]string,
+	error) {
+
+	matches, err := filepath.Glob(filepath.Join(checkpointDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	restore := make(map[string]string)
+	least := make(map[string]*Journal)
+	for _, match := range matches {
+		name := strings.SplitN(filepath.Base(match), destinationSeparator,
+			2)[0]
+		checkpoint, err := ReadJournal(match)
+		if err != nil {
+			lgr.Warnf("Error reading checkpoint %q: %q", match, err)
+			continue
+		}
+		if _, ok := least[name]; !ok || LessAdvanced(checkpoint,
+			least[name]) {
+			least[name] = checkpoint
+			restore[name] = match
+		}
+	}
+	return restore, nil
+}
+
+// ReadCheckpoint reads checkpoint of log stream id from checkpointDir
+func ReadCheckpoint(checkpointDir, id string) (*Journal, error) {
+	return ReadJournal(filepath.Join(checkpointDir, JournalName(id)))
+}
+
+// ReadCheckpoints reads checkpoints of the main and of all additional
+// destinations of log stream id
+func ReadCheckpoints(checkpointDir, id string, destinations int) (
+	[]*Journal, error) {
+
+	checkpoints := make([]*Journal, 0, destinations+1)
+	for i := 0; i <= destinations; i++ {
+		checkpoint, err := ReadJournal(filepath.Join(checkpointDir,
+			CheckpointName(id, i)))
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, nil
+}
+
+// LessAdvanced reports whether journal a is before journal b in a stream.
+// Files are compared by modification time, a missing file is the oldest.
+func LessAdvanced(a, b *Journal) bool {
+	if a.FileName == b.FileName {
+		return a.Seek < b.Seek
+	}
+	aInfo, err := os.Stat(a.FileName)
+	if err != nil {
+		return true
+	}
+	bInfo, err := os.Stat(b.FileName)
+	if err != nil {
+		return false
+	}
+	return aInfo.ModTime().Before(bInfo.ModTime())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	for _, d := range []string{logDir, checkpointDir} {
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	older := filepath.Join(logDir, "20160101_000000_1_UTC-name.szn")
+	newer := filepath.Join(logDir, "20160102_000000_1_UTC-name.szn")
+	writeTestLog(t, logDir, filepath.Base(older), 100, 2*time.Hour)
+	writeTestLog(t, logDir, filepath.Base(newer), 100, time.Hour)
+
+	id := StreamId(logDir, "name")
+	other := StreamId(logDir, "other")
+	for name, journal := range map[string]*Journal{
+		CheckpointName(id, 0):    {Seek: 10, FileName: newer},
+		CheckpointName(id, 1):    {Seek: 50, FileName: older},
+		CheckpointName(id, 2):    {Seek: 20, FileName: newer},
+		CheckpointName(other, 0): {Seek: 30, FileName: older},
+	} {
+		assert.Nil(t, writeJournal(filepath.Join(checkpointDir, name),
+			journal))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(checkpointDir,
+		CheckpointName(other, 1)), []byte("{"), 0644))
+
+	// the destination behind restores the journal of the stream, broken
+	// checkpoints are skipped
+	restore, err := LeastCheckpoints(checkpointDir, logrus.New())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		JournalName(id): filepath.Join(checkpointDir, CheckpointName(id, 1)),
+		JournalName(other): filepath.Join(checkpointDir,
+			CheckpointName(other, 0)),
+	}, restore)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// ledgerSuffix is extension of ledger files
+const ledgerSuffix = ".ledger"
+
+// LedgerEntry records a file completely delivered to all destinations of
+// its log stream. FirstRecord and LastRecord are times of the file, as
+// kafkafeeder does not read the records: its creation by its name or by
+// modification of the previous file, and its modification. Files are
+// identified by device and inode, a file renamed by rotation is recorded
+// once more under its new name.
+type LedgerEntry struct {
+	Path        string    `json:"path"`
+	Device      uint64    `json:"device"`
+	Inode       uint64    `json:"inode"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	FirstRecord time.Time `json:"first_record"`
+	LastRecord  time.Time `json:"last_record"`
+	Completed   time.Time `json:"completed"`
+}
+
+// fileKey identifies a file regardless of its name
+type fileKey struct {
+	device, inode uint64
+}
+
+// newFileKey returns key of file described by info, it is zero when the
+// file system does not provide it
+func newFileKey(info os.FileInfo) fileKey {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileKey{uint64(stat.Dev), uint64(stat.Ino)}
+	}
+	return fileKey{}
+}
+
+// Ledger is an append only list of delivered files of a log stream, one
+// JSON entry per line
+type Ledger struct {
+	path    string
+	Entries []*LedgerEntry
+	// the latest entries by file and by content
+	files     map[fileKey]*LedgerEntry
+	checksums map[string]*LedgerEntry
+}
+
+// LedgerPath returns ledger file of log stream id in ledgerDir
+func LedgerPath(ledgerDir, id string) string {
+	return filepath.Join(ledgerDir, id+ledgerSuffix)
+}
+
+// OpenLedger reads ledger of log stream id, it is empty when it does not
+// exist yet
+func OpenLedger(ledgerDir, id string) (*Ledger, error) {
+	l := &Ledger{path: LedgerPath(ledgerDir, id)}
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		l.files = make(map[fileKey]*LedgerEntry)
+		l.checksums = make(map[string]*LedgerEntry)
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err != nil {
+		return nil, err
+	}
+	return l, l.read(file)
+}
+
+// read replaces entries of the ledger by entries in file
+func (l *Ledger) read(file io.Reader) error {
+	l.Entries = nil
+	l.files = make(map[fileKey]*LedgerEntry)
+	l.checksums = make(map[string]*LedgerEntry)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := &LedgerEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return fmt.Errorf("Invalid ledger %q at line %d: %v", l.path,
+				line, err)
+		}
+		l.add(entry)
+	}
+	return scanner.Err()
+}
+
+// add indexes entry appended to the ledger
+func (l *Ledger) add(entry *LedgerEntry) {
+	l.Entries = append(l.Entries, entry)
+	l.files[fileKey{entry.Device, entry.Inode}] = entry
+	l.checksums[entry.SHA256] = entry
+}
+
+// Delivered reports whether file is recorded under its current name with
+// its current size
+func (l *Ledger) Delivered(file *LogFile) bool {
+	entry, ok := l.files[newFileKey(file.Info)]
+	return ok && entry.Path == file.Path && entry.Size == file.Info.Size()
+}
+
+// recorded reports whether file is recorded under its current name or
+// whether records were appended to it after it was recorded, then it is not
+// delivered as a whole and it is not recorded again. Otherwise it returns
+// checksum of the file.
+func (l *Ledger) recorded(file *LogFile) (skip bool, checksum string,
+	err error) {
+
+	size := file.Info.Size()
+	if entry, ok := l.files[newFileKey(file.Info)]; ok {
+		if entry.Path == file.Path && entry.Size == size {
+			return true, "", nil
+		}
+		if entry.Size < size {
+			// records appended after the file was delivered, unless the
+			// inode belongs to another file now
+			prefix, err := fileChecksum(file.Path, entry.Size)
+			if err != nil || prefix == entry.SHA256 {
+				return true, "", err
+			}
+		}
+	}
+	checksum, err = fileChecksum(file.Path, size)
+	return false, checksum, err
+}
+
+// fileChecksum returns SHA-256 of the first size bytes of file in hex, so
+// it matches the recorded size when the file grows meanwhile
+func fileChecksum(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, io.LimitReader(file, size)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// firstRecord returns time of the first record of files[i]. Names of
+// kafkalog files contain time of their creation, otherwise the records
+// follow the previous file.
+func firstRecord(files []*LogFile, i int) time.Time {
+	if date, ok := files[i].groups["Date"]; ok {
+		created, err := time.Parse("20060102150405",
+			date+files[i].groups["Time"])
+		if err == nil {
+			return created
+		}
+	}
+	if i > 0 {
+		return files[i-1].Info.ModTime()
+	}
+	return time.Time{}
+}
+
+// Record appends delivered files which are not recorded yet. All files of
+// the log stream ordered from the oldest have to be given, delivered ones
+// are their prefix. The ledger is locked and read again first, so it can be
+// recorded by several processes, and it is synced before it returns.
+func (l *Ledger) Record(files, delivered []*LogFile, now time.Time) (
+	recorded int, err error) {
+
+	if len(delivered) == 0 {
+		return 0, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	if err = syscall.Flock(int(out.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+	if err = l.read(out); err != nil {
+		return 0, err
+	}
+	var entries []*LedgerEntry
+	for i, file := range delivered {
+		skip, checksum, err := l.recorded(file)
+		if err != nil {
+			return 0, err
+		}
+		if skip {
+			continue
+		}
+		key := newFileKey(file.Info)
+		entry := &LedgerEntry{
+			Path:        file.Path,
+			Device:      key.device,
+			Inode:       key.inode,
+			Size:        file.Info.Size(),
+			SHA256:      checksum,
+			FirstRecord: firstRecord(files, i),
+			LastRecord:  file.Info.ModTime(),
+			Completed:   now,
+		}
+		// a rotated file keeps times of its delivery under the old name
+		if old, ok := l.checksums[checksum]; ok && old.Size == entry.Size {
+			entry.FirstRecord = old.FirstRecord
+			entry.LastRecord = old.LastRecord
+			entry.Completed = old.Completed
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = out.Write(append(data, '\n')); err != nil {
+			return 0, err
+		}
+	}
+	if err = out.Sync(); err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		l.add(entry)
+	}
+	return len(entries), nil
+}
+
+// UpdateLedger records files of log stream name in dir delivered by
+// checkpoints in checkpointDir, it returns the ledger
+func UpdateLedger(ledgerDir, checkpointDir, dir, name string,
+	cfg *TopicConfig, now time.Time) (*Ledger, error) {
+
+	id := StreamId(dir, name)
+	ledger, err := OpenLedger(ledgerDir, id)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ListStreamFiles(dir, name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	delivered, err := DeliveredFiles(checkpointDir, id,
+		len(cfg.Destinations), files)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = ledger.Record(files, delivered, now)
+	return ledger, err
+}
+
+// LedgerRow is a ledger entry of a log stream
+type LedgerRow struct {
+	Path  string // kafkafeeder.yaml
+	Name  string
+	Entry *LedgerEntry
+}
+
+type ledgerRowSorter []LedgerRow
+
+func (s ledgerRowSorter) Len() int      { return len(s) }
+func (s ledgerRowSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ledgerRowSorter) Less(i, j int) bool {
+	if s[i].Path != s[j].Path {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Name < s[j].Name
+}
+
+// LedgerRows updates ledgers of all kafkafeeders of logManager and returns
+// their entries. Only kafkafeeder at manifest and only topic are selected
+// when they are not empty, topic is a name of a log or a Kafka topic.
+func LedgerRows(ledgerDir, checkpointDir string, logManager *LogManager,
+	manifest, topic string) (rows []LedgerRow, err error) {
+
+	if manifest != "" {
+		if manifest, err = filepath.Abs(manifest); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	matched := false
+	logManager.Each(func(path string, log *LogConfig) {
+		if abs, _ := filepath.Abs(path); manifest != "" && abs != manifest {
+			return
+		}
+		for name, cfg := range log.Topics {
+			if err != nil {
+				return
+			}
+			if topic != "" && name != topic && cfg.Topic != topic {
+				continue
+			}
+			matched = true
+			var ledger *Ledger
+			ledger, err = UpdateLedger(ledgerDir, checkpointDir,
+				log.Directory, name, cfg, now)
+			if err != nil {
+				err = fmt.Errorf("Error updating ledger of %q in %q: %v",
+					name, log.Directory, err)
+				return
+			}
+			for _, entry := range ledger.Entries {
+				rows = append(rows, LedgerRow{Path: path, Name: name,
+					Entry: entry})
+			}
+		}
+	})
+	if err == nil && !matched {
+		err = fmt.Errorf("There is no matching log")
+	}
+	// entries of a log stay in order
+	sort.Stable(ledgerRowSorter(rows))
+	return rows, err
+}
+
+func WriteLedger(wr io.Writer, rows []LedgerRow) error {
+	tw := tabwriter.NewWriter(wr, 0, 8, 2, ' ', 0)
+	// times of records are approximated by times of the files
+	fmt.Fprintln(tw, "KAFKAFEEDER\tNAME\tFILE\tSIZE\tSHA256\t"+
+		"FILE_CREATED\tFILE_MODIFIED\tCOMPLETED")
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+	for _, r := range rows {
+		e := r.Entry
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", r.Path, r.Name,
+			e.Path, e.Size, e.SHA256, format(e.FirstRecord),
+			format(e.LastRecord), format(e.Completed))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	ledgerDir := filepath.Join(dir, "ledger")
+	assert.Nil(t, os.Mkdir(logDir, 0755))
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+	writeTestLog(t, logDir, "20160101_000000_1_UTC-name.szn", 10, 2*time.Hour)
+	writeTestLog(t, logDir, "20160102_000000_1_UTC-name.szn", 20, time.Hour)
+	writeTestLog(t, logDir, "20160103_000000_1_UTC-name.szn", 30, 0)
+	cfg := &TopicConfig{Type: "kafkalog", Retention: -1, RetentionSize: 0}
+	now := time.Now()
+
+	// nothing is delivered without checkpoints
+	ledger, err := UpdateLedger(ledgerDir, checkpointDir, logDir, "name",
+		cfg, now)
+	assert.Nil(t, err)
+	assert.Empty(t, ledger.Entries)
+
+	id := StreamId(logDir, "name")
+	stale, err := OpenLedger(ledgerDir, id)
+	assert.Nil(t, err)
+	assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+		CheckpointName(id, 0)), &Journal{Seek: 5, FileName: filepath.Join(
+		logDir, "20160103_000000_1_UTC-name.szn")}))
+	ledger, err = UpdateLedger(ledgerDir, checkpointDir, logDir, "name",
+		cfg, now)
+	assert.Nil(t, err)
+	assert.Len(t, ledger.Entries, 2)
+	entry := ledger.Entries[1]
+	assert.Equal(t, filepath.Join(logDir, "20160102_000000_1_UTC-name.szn"),
+		entry.Path)
+	assert.Equal(t, int64(20), entry.Size)
+	// sha256 of 20 zero bytes
+	assert.Equal(t, "de47c9b27eb8d300dbb5f2c353e632c3"+
+		"93262cf06340c4fa7f1b40c4cbd36f90", entry.SHA256)
+	assert.Equal(t, time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+		entry.FirstRecord)
+
+	// entries are recorded once and read back
+	ledger, err = UpdateLedger(ledgerDir, checkpointDir, logDir, "name",
+		cfg, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, ledger.Entries, 2)
+	read, err := OpenLedger(ledgerDir, id)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.SHA256, read.Entries[1].SHA256)
+	assert.True(t, read.Entries[1].Completed.Equal(now))
+
+	// entries recorded meanwhile by another process are not recorded again
+	files, err := ListStreamFiles(logDir, "name", cfg)
+	assert.Nil(t, err)
+	recorded, err := stale.Record(files, files[:2], now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, recorded)
+	assert.Len(t, stale.Entries, 2)
+
+	// the cleaner removes only files recorded with their size
+	writeTestLog(t, logDir, "20160102_000000_1_UTC-name.szn", 25, time.Hour)
+	// only the size of a file which grows meanwhile is hashed
+	checksum, err := fileChecksum(entry.Path, entry.Size)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.SHA256, checksum)
+	lm, err := NewLogManager(&HekadConfig{})
+	assert.Nil(t, err)
+	cleaner, err := NewLogCleaner(logrus.New(), &CleanerConfig{Interval: 1},
+		checkpointDir, ledgerDir, lm, make(chan struct{}), &sync.WaitGroup{})
+	assert.Nil(t, err)
+	cleaner.cleanTopic(logDir, "name", cfg)
+	infos, err := ioutil.ReadDir(logDir)
+	assert.Nil(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "20160102_000000_1_UTC-name.szn", infos[0].Name())
+}
+
+func TestLedgerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	ledgerDir := filepath.Join(dir, "ledger")
+	assert.Nil(t, os.Mkdir(logDir, 0755))
+	assert.Nil(t, os.Mkdir(checkpointDir, 0755))
+	path := func(name string) string {
+		return filepath.Join(logDir, name)
+	}
+	write := func(name, data string) {
+		assert.Nil(t, ioutil.WriteFile(path(name), []byte(data), 0644))
+	}
+	cfg := &TopicConfig{Type: "plaintext", Retention: -1,
+		Options: &plaintextOptions{fileOptions{Rotation: rotationLogrotate}}}
+	id := StreamId(logDir, "access")
+	assert.Nil(t, writeJournal(filepath.Join(checkpointDir,
+		CheckpointName(id, 0)), &Journal{FileName: path("access.log")}))
+	write("access.log.1", "first\n")
+	write("access.log", "second\n")
+	now := time.Now()
+	ledger, err := UpdateLedger(ledgerDir, checkpointDir, logDir, "access",
+		cfg, now)
+	assert.Nil(t, err)
+	assert.Len(t, ledger.Entries, 1)
+	assert.Equal(t, path("access.log.1"), ledger.Entries[0].Path)
+	assert.NotZero(t, ledger.Entries[0].Inode)
+
+	// logrotate renames the files and creates a new one, rotated files are
+	// recorded under their new names with times of their delivery
+	assert.Nil(t, os.Rename(path("access.log.1"), path("access.log.2")))
+	assert.Nil(t, os.Rename(path("access.log"), path("access.log.1")))
+	write("access.log", "third\n")
+	ledger, err = UpdateLedger(ledgerDir, checkpointDir, logDir, "access",
+		cfg, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, ledger.Entries, 3)
+	assert.Equal(t, path("access.log.2"), ledger.Entries[1].Path)
+	assert.Equal(t, ledger.Entries[0].SHA256, ledger.Entries[1].SHA256)
+	assert.True(t, ledger.Entries[1].Completed.Equal(now))
+	assert.Equal(t, path("access.log.1"), ledger.Entries[2].Path)
+	assert.True(t, ledger.Entries[2].Completed.Equal(now.Add(time.Hour)))
+	files, err := ListStreamFiles(logDir, "access", cfg)
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+	assert.True(t, ledger.Delivered(files[0]))
+	assert.True(t, ledger.Delivered(files[1]))
+	assert.False(t, ledger.Delivered(files[2]))
+
+	// records appended after delivery were not delivered
+	file, err := os.OpenFile(path("access.log.1"), os.O_WRONLY|os.O_APPEND,
+		0644)
+	assert.Nil(t, err)
+	_, err = file.WriteString("late\n")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	files, err = ListStreamFiles(logDir, "access", cfg)
+	assert.Nil(t, err)
+	recorded, err := ledger.Record(files, files[:2], now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, recorded)
+	assert.False(t, ledger.Delivered(files[1]))
+
+	// times are of the files, not of their records
+	var b bytes.Buffer
+	assert.Nil(t, WriteLedger(&b, []LedgerRow{{Path: "kafkafeeder.yaml",
+		Name: "access", Entry: ledger.Entries[0]}}))
+	assert.Regexp(t, `^KAFKAFEEDER +NAME +FILE +SIZE +SHA256 +FILE_CREATED +`+
+		`FILE_MODIFIED +COMPLETED\n`, b.String())
+}
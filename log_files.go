@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogFile is a single file of a log stream
+type LogFile struct {
+	Path   string
+	Info   os.FileInfo
+	groups map[string]string
+}
+
+type logFilesSorter struct {
+	files    []*LogFile
+	priority []string
+}
+
+func (s *logFilesSorter) Len() int {
+	return len(s.files)
+}
+
+func (s *logFilesSorter) Swap(i, j int) {
+	s.files[i], s.files[j] = s.files[j], s.files[i]
+}
+
+func (s *logFilesSorter) Less(i, j int) bool {
+	for _, group := range s.priority {
+		reverse := strings.HasPrefix(group, "^")
+		group = strings.TrimPrefix(group, "^")
+		a, b := s.files[i].groups[group], s.files[j].groups[group]
+		if a == b {
+			continue
+		}
+		var less bool
+		aNum, aErr := strconv.ParseInt(emptyAsZero(a), 10, 64)
+		bNum, bErr := strconv.ParseInt(emptyAsZero(b), 10, 64)
+		if aErr == nil && bErr == nil {
+			if aNum == bNum {
+				continue
+			}
+			less = aNum < bNum
+		} else {
+			less = a < b
+		}
+		return less != reverse
+	}
+	return s.files[i].Path < s.files[j].Path
+}
+
+func emptyAsZero(value string) string {
+	if value == "" {
+		return "0"
+	}
+	return value
+}
+
+// ListLogFiles returns files in dir matching fileMatch ordered the same way
+// as hekad logstreamer reads them, which is from the oldest to the newest.
+// Same as logstreamer, fileMatch is matched against paths relative to dir,
+// subdirectories are searched only when fileMatch contains a separator.
+func ListLogFiles(dir, fileMatch string, priority []string) (
+	[]*LogFile, error) {
+
+	return listLogFiles(dir, fileMatch, priority,
+		strings.Contains(fileMatch, "/"))
+}
+
+// ListStreamFiles returns files of log stream name in dir like ListLogFiles,
+// subdirectories are searched also when the log type says so
+func ListStreamFiles(dir, name string, cfg *TopicConfig) ([]*LogFile,
+	error) {
+
+	logType, ok := GetLogType(cfg.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported type %q", cfg.Type)
+	}
+	fileMatch := logType.FileMatch(name, cfg)
+	recursive := strings.Contains(fileMatch, "/")
+	if r, ok := logType.(LogTypeRecursive); ok && r.Recursive(cfg) {
+		recursive = true
+	}
+	return listLogFiles(dir, fileMatch, logType.Priority(cfg), recursive)
+}
+
+func listLogFiles(dir, fileMatch string, priority []string,
+	recursive bool) ([]*LogFile, error) {
+
+	re, err := regexp.Compile("^(?:" + fileMatch + ")$")
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	files := make([]*LogFile, 0)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// unreadable subdirectories are skipped as by logstreamer
+			return nil
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		match := re.FindStringSubmatch(filepath.ToSlash(rel))
+		if match == nil {
+			return nil
+		}
+		file := &LogFile{
+			Path:   path,
+			Info:   info,
+			groups: make(map[string]string),
+		}
+		for i, name := range names {
+			if name != "" {
+				file.groups[name] = match[i]
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(&logFilesSorter{files: files, priority: priority})
+	return files, nil
+}
+
+// validateFileMatch checks that fileMatch is a valid regular expression and
+// that it contains all match groups of priority
+func validateFileMatch(fileMatch string, priority []string) error {
+	if strings.Contains(fileMatch, "'") {
+		return fmt.Errorf("Invalid file_match: ' is not allowed")
+	}
+	re, err := regexp.Compile(fileMatch)
+	if err != nil {
+		return fmt.Errorf("Invalid file_match: %v", err)
+	}
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		groups[name] = true
+	}
+	for _, group := range priority {
+		if !groups[strings.TrimPrefix(group, "^")] || group == "^" {
+			return fmt.Errorf("Priority %q is not a match group of "+
+				"file_match", group)
+		}
+	}
+	return nil
+}
+
+// FileIndex returns index of file with path in files or -1 if there is none
+func FileIndex(files []*LogFile, path string) int {
+	path = filepath.Clean(path)
+	for i, file := range files {
+		if file.Path == path {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListLogFilesLogrotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"access.log", "access.log.1",
+		"access.log.2.gz", "access.log.10.gz", "error.log", "error.log.1"} {
+		writeTestLog(t, dir, name, 1, 0)
+	}
+
+	options := &fileOptions{Rotation: rotationLogrotate, File: "access.log"}
+	assert.Nil(t, options.validate())
+	files, err := ListLogFiles(dir, options.fileMatch("access"),
+		options.priority())
+	assert.Nil(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file.Path))
+	}
+	assert.Equal(t, []string{"access.log.10.gz", "access.log.2.gz",
+		"access.log.1", "access.log"}, names)
+
+	options = &fileOptions{Rotation: rotationLogrotate}
+	files, err = ListLogFiles(dir, options.fileMatch("error"),
+		options.priority())
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "error.log", filepath.Base(files[1].Path))
+}
+
+func TestListLogFilesSubdirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"2016", "private"} {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, sub), 0755))
+	}
+	writeTestLog(t, dir, "a.log", 1, 0)
+	writeTestLog(t, dir, "2016/b.log", 1, 0)
+	assert.Nil(t, os.Chmod(filepath.Join(dir, "private"), 0))
+	defer os.Chmod(filepath.Join(dir, "private"), 0755)
+
+	names := func(files []*LogFile) (names []string) {
+		for _, file := range files {
+			rel, _ := filepath.Rel(dir, file.Path)
+			names = append(names, rel)
+		}
+		return
+	}
+	files, err := ListLogFiles(dir, `.*\.log`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, names(files), []string{"a.log"})
+
+	// unreadable subdirectories are skipped
+	files, err = ListLogFiles(dir, `2016/.*\.log`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, names(files), []string{"2016/b.log"})
+
+	// custom file_match is matched also in subdirectories
+	files, err = ListStreamFiles(dir, "name", &TopicConfig{
+		Type: "custom",
+		Options: &customOptions{
+			fileOptions: fileOptions{FileMatch: `.*\.log`},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, names(files), []string{"2016/b.log", "a.log"})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -12,27 +13,103 @@ const (
 	ACK_DISABLED     = 0
 )
 
+const (
+	ENCODING_RAW      = "raw"
+	ENCODING_JSON     = "json"
+	ENCODING_PROTOBUF = "protobuf"
+)
+
+const (
+	PARTITIONING_AUTO        = ""
+	PARTITIONING_RANDOM      = "random"
+	PARTITIONING_ROUND_ROBIN = "round-robin"
+	PARTITIONING_HASH        = "hash"
+	PARTITIONING_SOURCE      = "source"
+)
+
+// Partitioning chooses Kafka partitions of messages. Automatic partitioning
+// hashes the key field when the log type sets it and is random otherwise.
+type Partitioning struct {
+	Strategy string
+	Field    string // hashed field
+}
+
+const (
+	ON_ERROR_RETRY  = "retry"
+	ON_ERROR_DROP   = "drop"
+	ON_ERROR_DIVERT = "divert"
+)
+
+// OnError is a policy for messages which Kafka does not accept. They are
+// retried forever, or retried Tries times and then dropped or diverted into
+// the dead-letter spool. Empty Action diverts when the stream has a spool
+// and retries otherwise.
+type OnError struct {
+	Action string
+	Tries  int
+}
+
+// Destination is an additional topic receiving messages of a log stream
+type Destination struct {
+	Topic  string
+	Broker string
+	Ack    int
+}
+
+// Route sends messages of a log stream matching a heka message matcher also
+// into another topic
+type Route struct {
+	Match      string
+	Topic      string
+	TopicField string // field with name of topic, used instead of Topic
+	Broker     string
+	Ack        int
+}
+
+// Filter selects messages of a log stream which are sent to Kafka
+type Filter struct {
+	Include string  // matcher of sent messages, all are sent when empty
+	Exclude string  // matcher of dropped messages
+	Sample  float64 // percentage of sent messages, all are sent when zero
+}
+
 type TopicConfig struct {
-	Topic     string
-	Type      string
-	Broker    string
-	Retention time.Duration
-	Ack       int
+	Topic         string
+	Type          string
+	Broker        string
+	Retention     time.Duration // max age of read files, negative when unset
+	RetentionSize int64         // max size of all files, negative when unset
+	Ack           int
+	Encoding      string
+	Fields        map[string]string // static fields attached to messages
+	Partitioning  Partitioning
+	Producer      Producer // overrides of producer defaults
+	OnError       OnError  // policy for messages rejected by Kafka
+	Destinations  []Destination
+	Routes        []Route
+	Filter        Filter
+	Redact        []RedactRule // applied after mandatory rules of hekad
+	StartFrom     StartFrom    // position of a new log stream
+	Options       interface{}  // log type specific options
 }
 
 type LogConfig struct {
 	ModTime   time.Time
 	Directory string
 	Topics    map[string]*TopicConfig
+	Error     error // why was the kafkafeeder rejected, nil when valid
 }
 
 type LogManager struct {
-	Logs map[string]*LogConfig
+	Logs     map[string]*LogConfig
+	hekadCfg *HekadConfig
+	mutex    sync.RWMutex
 }
 
-func NewLogManager() (*LogManager, error) {
+func NewLogManager(hekadCfg *HekadConfig) (*LogManager, error) {
 	lm := &LogManager{
-		Logs: make(map[string]*LogConfig),
+		Logs:     make(map[string]*LogConfig),
+		hekadCfg: hekadCfg,
 	}
 	return lm, nil
 }
@@ -40,6 +117,8 @@ func NewLogManager() (*LogManager, error) {
 func (lm *LogManager) Add(linkPath string, file string, finfo os.FileInfo) (
 	bool, error) {
 
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
 	logCfg, ok := lm.Logs[file]
 	if ok { // already exists
 		if finfo.ModTime() == logCfg.ModTime ||
@@ -49,8 +128,11 @@ func (lm *LogManager) Add(linkPath string, file string, finfo os.FileInfo) (
 	}
 	// create new - when parsing fails add anyway
 	logCfg, err := ParseFile(file)
-	if logCfg == nil {
-		logCfg = &LogConfig{}
+	if err == nil {
+		err = logCfg.Validate(lm.hekadCfg)
+	}
+	if err != nil {
+		logCfg = &LogConfig{Error: err}
 	}
 	logCfg.ModTime = finfo.ModTime()
 	logCfg.Directory, _ = filepath.Abs(filepath.Dir(linkPath))
@@ -58,7 +140,32 @@ func (lm *LogManager) Add(linkPath string, file string, finfo os.FileInfo) (
 	return err == nil, err
 }
 
+// Errors returns reasons of rejection of all invalid kafkafeeders
+func (lm *LogManager) Errors() map[string]error {
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+	errs := make(map[string]error)
+	for path, logCfg := range lm.Logs {
+		if logCfg.Error != nil {
+			errs[path] = logCfg.Error
+		}
+	}
+	return errs
+}
+
+// Each calls fn for every known kafkafeeder, logs can not be changed
+// meanwhile
+func (lm *LogManager) Each(fn func(path string, logCfg *LogConfig)) {
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+	for path, logCfg := range lm.Logs {
+		fn(path, logCfg)
+	}
+}
+
 func (lm *LogManager) KeepValid() bool {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
 	change := false
 	for path, _ := range lm.Logs {
 		_, err := os.Stat(path)
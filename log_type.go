@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LogType describes how hekad reads and decodes logs of one type
+type LogType interface {
+	// ParseOptions reads and validates type specific options of a topic,
+	// unmarshal decodes the whole topic section of kafkafeeder.yaml
+	ParseOptions(unmarshal func(interface{}) error) (interface{}, error)
+	// FileMatch returns regular expression matching files of log name
+	FileMatch(name string, cfg *TopicConfig) string
+	// Priority returns match groups of FileMatch ordering files of the log
+	Priority(cfg *TopicConfig) []string
+	// Splitter returns configuration of hekad splitter
+	Splitter(cfg *TopicConfig) string
+	// Decoder returns configuration of hekad decoder section name, it has to
+	// set type of decoded messages to id
+	Decoder(name, id string, cfg *TopicConfig) string
+}
+
+// LogTypeValidator is implemented by log types which have to validate
+// options of a topic against the global configuration
+type LogTypeValidator interface {
+	Validate(cfg *TopicConfig, hekadCfg *HekadConfig) error
+}
+
+// LogTypeFields is implemented by log types which know all fields set by
+// their decoder
+type LogTypeFields interface {
+	Fields(cfg *TopicConfig) []string
+}
+
+// LogTypeRecursive is implemented by log types whose file_match can match
+// files in subdirectories also without a separator
+type LogTypeRecursive interface {
+	Recursive(cfg *TopicConfig) bool
+}
+
+var logTypes = make(map[string]LogType)
+
+// RegisterLogType makes log type available under name, it is meant to be
+// called from init functions
+func RegisterLogType(name string, logType LogType) {
+	if _, ok := logTypes[name]; ok {
+		panic(fmt.Sprintf("Log type %q registered twice", name))
+	}
+	logTypes[name] = logType
+}
+
+func GetLogType(name string) (LogType, bool) {
+	logType, ok := logTypes[name]
+	return logType, ok
+}
+
+// LogTypeNames returns sorted names of all registered log types
+func LogTypeNames() []string {
+	names := make([]string, 0, len(logTypes))
+	for name := range logTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
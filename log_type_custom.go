@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// customType reads logs of any naming, the manifest chooses how files are
+// split into records and how records are decoded
+type customType struct{}
+
+type customOptions struct {
+	fileOptions `yaml:",inline"`
+	// Splitter is one of line, regex, kafkalog or null
+	Splitter string `yaml:"splitter"`
+	// Delimiter and DelimiterEol configure the regex splitter
+	Delimiter    string `yaml:"delimiter"`
+	DelimiterEol *bool  `yaml:"delimiter_eol"`
+	// Decoder is one of payload, kafkalog or json
+	Decoder string `yaml:"decoder"`
+	// Key is a JSON field used as the message key by the json decoder
+	Key string `yaml:"key"`
+}
+
+var (
+	customSplitters = []string{"line", "regex", "kafkalog", "null"}
+	customDecoders  = []string{"payload", "kafkalog", "json"}
+)
+
+func init() {
+	RegisterLogType("custom", &customType{})
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *customType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &customOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if options.FileMatch == "" && options.Rotation == "" {
+		return nil, errors.New("File_match can not be empty")
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.FileMatch != "" &&
+		len(regexp.MustCompile(options.FileMatch).SubexpNames()) > 1 &&
+		len(options.Priority) == 0 {
+		return nil, errors.New("Priority can not be empty when file_match " +
+			"has match groups")
+	}
+
+	if options.Splitter == "" {
+		options.Splitter = "line"
+	}
+	if !oneOf(options.Splitter, customSplitters) {
+		return nil, fmt.Errorf("Unknown splitter %q, supported are %v",
+			options.Splitter, customSplitters)
+	}
+	if options.Splitter == "regex" {
+		if options.Delimiter == "" {
+			return nil, errors.New("Delimiter can not be empty")
+		}
+		if strings.Contains(options.Delimiter, "'") {
+			return nil, errors.New("Invalid delimiter: ' is not allowed")
+		}
+		if _, err := regexp.Compile(options.Delimiter); err != nil {
+			return nil, fmt.Errorf("Invalid delimiter: %v", err)
+		}
+	} else if options.Delimiter != "" || options.DelimiterEol != nil {
+		return nil, errors.New("Delimiter can be used only with regex " +
+			"splitter")
+	}
+
+	if options.Decoder == "" {
+		options.Decoder = "payload"
+	}
+	if !oneOf(options.Decoder, customDecoders) {
+		return nil, fmt.Errorf("Unknown decoder %q, supported are %v",
+			options.Decoder, customDecoders)
+	}
+	if options.Decoder != "json" && options.Key != "" {
+		return nil, errors.New("Key can be used only with json decoder")
+	}
+	return options, nil
+}
+
+func (t *customType) options(cfg *TopicConfig) *customOptions {
+	if options, ok := cfg.Options.(*customOptions); ok {
+		return options
+	}
+	return &customOptions{Splitter: "line", Decoder: "payload"}
+}
+
+func (t *customType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+// Recursive is true for file_match of the user, which can match nested paths
+// e.g. by .*
+func (t *customType) Recursive(cfg *TopicConfig) bool {
+	return t.options(cfg).FileMatch != ""
+}
+
+func (t *customType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+func (t *customType) Splitter(cfg *TopicConfig) string {
+	options := t.options(cfg)
+	switch options.Splitter {
+	case "regex":
+		delimiterEol := true
+		if options.DelimiterEol != nil {
+			delimiterEol = *options.DelimiterEol
+		}
+		return `type = "RegexSplitter"` + "\n" +
+			fmt.Sprintf(`delimiter = '%s'`, options.Delimiter) + "\n" +
+			fmt.Sprintf(`delimiter_eol = %t`, delimiterEol)
+	case "kafkalog":
+		return (&kafkalogType{}).Splitter(cfg)
+	case "null":
+		return `type = "NullSplitter"`
+	}
+	return (&plaintextType{}).Splitter(cfg)
+}
+
+func (t *customType) Decoder(name, id string, cfg *TopicConfig) string {
+	options := t.options(cfg)
+	switch options.Decoder {
+	case "kafkalog":
+		return (&kafkalogType{}).Decoder(name, id, cfg)
+	case "json":
+		return (&jsonlinesType{}).Decoder(name, id, &TopicConfig{
+			Options: &jsonlinesOptions{Key: options.Key},
+		})
+	}
+	return scribbleDecoder(name, id)
+}
+
+func (t *customType) Fields(cfg *TopicConfig) []string {
+	options := t.options(cfg)
+	if options.Decoder == "kafkalog" ||
+		(options.Decoder == "json" && options.Key != "") {
+		return []string{"key"}
+	}
+	return nil
+}
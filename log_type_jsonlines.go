@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+)
+
+const malformedSuffix = "-malformed"
+
+// jsonlinesType reads newline delimited JSON, every line is sent as a single
+// message
+type jsonlinesType struct {
+	plaintextType
+}
+
+type jsonlinesOptions struct {
+	fileOptions `yaml:",inline"`
+	// Key is a JSON field used as the message key
+	Key string `yaml:"key"`
+	// MalformedTopic receives lines which are not JSON objects, they are
+	// dropped when it is empty
+	MalformedTopic string `yaml:"malformed_topic"`
+}
+
+func init() {
+	RegisterLogType("jsonlines", &jsonlinesType{})
+}
+
+func (t *jsonlinesType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &jsonlinesOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.MalformedTopic != "" {
+		if err := validTopicName(options.MalformedTopic); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+func (t *jsonlinesType) options(cfg *TopicConfig) *jsonlinesOptions {
+	if options, ok := cfg.Options.(*jsonlinesOptions); ok {
+		return options
+	}
+	return &jsonlinesOptions{}
+}
+
+func (t *jsonlinesType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+func (t *jsonlinesType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+func (t *jsonlinesType) Decoder(name, id string, cfg *TopicConfig) string {
+	options := t.options(cfg)
+	decoder := `type = "SandboxDecoder"` + "\n" +
+		`filename = "kafkafeeder/jsonlines.lua"` + "\n" +
+		fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("type = %s", tomlString(id))
+	if options.Key != "" {
+		decoder += fmt.Sprintf("\nkey_field = %s", tomlString(options.Key))
+	}
+	if options.MalformedTopic != "" {
+		decoder += fmt.Sprintf("\nmalformed_type = %s",
+			tomlString(id+malformedSuffix))
+	}
+	return decoder
+}
+
+func (t *jsonlinesType) Fields(cfg *TopicConfig) []string {
+	if t.options(cfg).Key == "" {
+		return nil
+	}
+	return []string{"key"}
+}
+
+func (t *jsonlinesType) SideOutputs(cfg *TopicConfig) []SideOutput {
+	options := t.options(cfg)
+	if options.MalformedTopic == "" {
+		return nil
+	}
+	return []SideOutput{{Suffix: malformedSuffix, Topic: options.MalformedTopic}}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+)
+
+// kafkalogType reads kafkalog files created e.g. by kafkalog-logrus hook
+type kafkalogType struct{}
+
+func init() {
+	RegisterLogType("kafkalog", &kafkalogType{})
+}
+
+func (t *kafkalogType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	return nil, nil
+}
+
+func (t *kafkalogType) FileMatch(name string, cfg *TopicConfig) string {
+	return fmt.Sprintf(`(?P<Date>\d+)_(?P<Time>\d+)_\d+_UTC-%s\.szn`, name)
+}
+
+func (t *kafkalogType) Priority(cfg *TopicConfig) []string {
+	return []string{"Date", "Time"}
+}
+
+func (t *kafkalogType) Splitter(cfg *TopicConfig) string {
+	return `type = "KafkalogSplitter"`
+}
+
+func (t *kafkalogType) Decoder(name, id string, cfg *TopicConfig) string {
+	return fmt.Sprintf(`type = "KafkalogDecoder"`+"\n"+
+		`msg_type = "%s"`, id)
+}
+
+func (t *kafkalogType) Fields(cfg *TopicConfig) []string {
+	return []string{"key"}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const luaDecodersDir = "lua_decoders"
+
+// luaType reads newline delimited logs decoded by a sandbox decoder script
+// from lua_decoders directory of hekad share_dir. Fields set by the script
+// are not known.
+type luaType struct{}
+
+type luaOptions struct {
+	fileOptions `yaml:",inline"`
+	// Script is a file name in lua_decoders directory of hekad share_dir
+	Script string                 `yaml:"script"`
+	Config map[string]interface{} `yaml:"config"`
+}
+
+func init() {
+	RegisterLogType("lua", &luaType{})
+}
+
+// tomlValue formats value of sandbox config as toml
+func tomlValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return tomlString(v), nil
+	case int, int64, uint64:
+		return fmt.Sprintf("%d", v), nil
+	case float64:
+		return fmt.Sprintf("%v", v), nil
+	case bool:
+		return fmt.Sprintf("%t", v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+func (t *luaType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &luaOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.Script == "" {
+		return nil, errors.New("Script can not be empty")
+	}
+	if filepath.Base(options.Script) != options.Script ||
+		!strings.HasSuffix(options.Script, ".lua") {
+		return nil, fmt.Errorf("Invalid script %q, it has to be a name of "+
+			".lua file in %s", options.Script, luaDecodersDir)
+	}
+	for key, value := range options.Config {
+		if key == "type" {
+			return nil, errors.New("Config type is set by kafkafeeder")
+		}
+		if !tomlKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("Invalid config key %q", key)
+		}
+		if _, err := tomlValue(value); err != nil {
+			return nil, fmt.Errorf("Invalid config %q: %v", key, err)
+		}
+	}
+	return options, nil
+}
+
+func (t *luaType) Validate(cfg *TopicConfig, hekadCfg *HekadConfig) error {
+	if hekadCfg.ShareDir == "" {
+		return errors.New("Lua decoders need hekad share_dir configured")
+	}
+	path := filepath.Join(hekadCfg.ShareDir, t.filename(cfg))
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Script %q not found: %v", t.options(cfg).Script,
+			err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("Script %q is not a file", path)
+	}
+	return nil
+}
+
+func (t *luaType) options(cfg *TopicConfig) *luaOptions {
+	if options, ok := cfg.Options.(*luaOptions); ok {
+		return options
+	}
+	return &luaOptions{}
+}
+
+// filename returns path of the script relative to share_dir
+func (t *luaType) filename(cfg *TopicConfig) string {
+	return luaDecodersDir + "/" + t.options(cfg).Script
+}
+
+func (t *luaType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+func (t *luaType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+func (t *luaType) Splitter(cfg *TopicConfig) string {
+	return (&plaintextType{}).Splitter(cfg)
+}
+
+func (t *luaType) Decoder(name, id string, cfg *TopicConfig) string {
+	options := t.options(cfg)
+	keys := make([]string, 0, len(options.Config))
+	for key := range options.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	decoder := `type = "SandboxDecoder"` + "\n" +
+		fmt.Sprintf("filename = %s\n", tomlString(t.filename(cfg))) +
+		fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("type = %s", tomlString(id))
+	for _, key := range keys {
+		value, _ := tomlValue(options.Config[key])
+		decoder += fmt.Sprintf("\n%s = %s", key, value)
+	}
+	return decoder
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// multilineType reads text logs with records spanning multiple lines, e.g.
+// stack traces. Record starts on a line matching the start regex.
+type multilineType struct{}
+
+type multilineOptions struct {
+	fileOptions `yaml:",inline"`
+	// Start is a regular expression matching the first line of a record
+	Start   string `yaml:"start"`
+	MaxSize string `yaml:"max_size"`
+	maxSize int64
+}
+
+func init() {
+	RegisterLogType("multiline", &multilineType{})
+}
+
+func (t *multilineType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &multilineOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if options.Start == "" {
+		return nil, errors.New("Start can not be empty")
+	}
+	if strings.Contains(options.Start, "'") {
+		return nil, errors.New("Invalid start: ' is not allowed")
+	}
+	if _, err := regexp.Compile(options.Start); err != nil {
+		return nil, fmt.Errorf("Invalid start: %v", err)
+	}
+	if options.MaxSize != "" {
+		size, err := ParseSize(options.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid max_size: %v", err)
+		}
+		if size <= 0 {
+			return nil, errors.New("Max_size have to be positive")
+		}
+		options.maxSize = size
+	}
+	return options, nil
+}
+
+func (t *multilineType) Validate(cfg *TopicConfig,
+	hekadCfg *HekadConfig) error {
+
+	if maxSize := t.options(cfg).maxSize; maxSize > hekadCfg.MaxMessageSize {
+		return fmt.Errorf("Max_size %d is bigger than hekad "+
+			"max_message_size %d", maxSize, hekadCfg.MaxMessageSize)
+	}
+	return nil
+}
+
+func (t *multilineType) options(cfg *TopicConfig) *multilineOptions {
+	if options, ok := cfg.Options.(*multilineOptions); ok {
+		return options
+	}
+	return &multilineOptions{}
+}
+
+func (t *multilineType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+func (t *multilineType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+// Splitter splits records on a new line followed by the start of a record,
+// the start is captured, so it is kept in the record. It is matched in multi
+// line mode, so that ^ of all its alternatives matches after the new line.
+func (t *multilineType) Splitter(cfg *TopicConfig) string {
+	return `type = "RegexSplitter"` + "\n" +
+		fmt.Sprintf(`delimiter = '\n((?m:%s))'`, t.options(cfg).Start) +
+		"\n" +
+		`delimiter_eol = false` + "\n" +
+		`keep_truncated = true`
+}
+
+// Decoder truncates records at max_size, the output limit of the sandbox is
+// derived from it. Without max_size the converter derives it from hekad
+// max_message_size.
+func (t *multilineType) Decoder(name, id string, cfg *TopicConfig) string {
+	maxSize := t.options(cfg).maxSize
+	decoder := `type = "SandboxDecoder"` + "\n" +
+		`filename = "kafkafeeder/multiline.lua"` + "\n"
+	if maxSize > 0 {
+		decoder += fmt.Sprintf("output_limit = %d\n",
+			sandboxOutputLimit(maxSize))
+	}
+	decoder += fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("type = %s", tomlString(id))
+	if maxSize > 0 {
+		decoder += fmt.Sprintf("\nmax_size = %d", maxSize)
+	}
+	return decoder
+}
+
+func (t *multilineType) Fields(cfg *TopicConfig) []string {
+	return nil
+}
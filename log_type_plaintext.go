@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const rotationLogrotate = "logrotate"
+
+// fileOptions are options of log types which read files of any name
+type fileOptions struct {
+	FileMatch string   `yaml:"file_match"`
+	Priority  []string `yaml:"priority"`
+	// Rotation logrotate reads files rotated by logrotate, i.e. file,
+	// file.1, file.2.gz, ... from the highest index to the current file
+	Rotation string `yaml:"rotation"`
+	// File is the current file of the logrotate rotated log
+	File string `yaml:"file"`
+}
+
+func (o *fileOptions) validate() error {
+	switch o.Rotation {
+	case "":
+		if o.File != "" {
+			return fmt.Errorf("File can be used only with rotation")
+		}
+	case rotationLogrotate:
+		if o.FileMatch != "" || len(o.Priority) > 0 {
+			return fmt.Errorf("File_match and priority can not be used " +
+				"with rotation")
+		}
+		if strings.ContainsAny(o.File, "/'") {
+			return fmt.Errorf("Invalid file %q", o.File)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown rotation %q, supported is %q", o.Rotation,
+			rotationLogrotate)
+	}
+	if o.FileMatch == "" {
+		if len(o.Priority) > 0 {
+			return fmt.Errorf("Priority can not be used without file_match")
+		}
+		return nil
+	}
+	return validateFileMatch(o.FileMatch, o.Priority)
+}
+
+// fileMatch returns regular expression matching files of log name, by
+// default it is <name>.log
+func (o *fileOptions) fileMatch(name string) string {
+	if o.Rotation == rotationLogrotate {
+		file := o.File
+		if file == "" {
+			file = name + ".log"
+		}
+		return regexp.QuoteMeta(file) + `(?:\.(?P<Index>\d+))?(?:\.gz)?`
+	}
+	if o.FileMatch == "" {
+		return regexp.QuoteMeta(name + ".log")
+	}
+	return o.FileMatch
+}
+
+func (o *fileOptions) priority() []string {
+	if o.Rotation == rotationLogrotate {
+		return []string{"^Index"}
+	}
+	if o.Priority == nil {
+		return []string{}
+	}
+	return o.Priority
+}
+
+// plaintextType reads newline delimited text logs, every line is sent as
+// a single message
+type plaintextType struct{}
+
+type plaintextOptions struct {
+	fileOptions `yaml:",inline"`
+}
+
+func init() {
+	RegisterLogType("plaintext", &plaintextType{})
+}
+
+func (t *plaintextType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &plaintextOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func (t *plaintextType) options(cfg *TopicConfig) *plaintextOptions {
+	if options, ok := cfg.Options.(*plaintextOptions); ok {
+		return options
+	}
+	return &plaintextOptions{}
+}
+
+func (t *plaintextType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+func (t *plaintextType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+func (t *plaintextType) Splitter(cfg *TopicConfig) string {
+	return `type = "TokenSplitter"` + "\n" +
+		`delimiter = '\n'`
+}
+
+func (t *plaintextType) Decoder(name, id string, cfg *TopicConfig) string {
+	return scribbleDecoder(name, id)
+}
+
+func (t *plaintextType) Fields(cfg *TopicConfig) []string {
+	return nil
+}
+
+// scribbleDecoder returns configuration of decoder which only sets type of
+// messages to id and keeps payload untouched
+func scribbleDecoder(name, id string) string {
+	return `type = "ScribbleDecoder"` + "\n" +
+		fmt.Sprintf("[%s.message_fields]\n", name) +
+		fmt.Sprintf(`Type = "%s"`, id)
+}
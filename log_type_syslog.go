@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+)
+
+// syslogType reads syslog files in RFC5424 or RFC3164 format, lines are
+// parsed into fields
+type syslogType struct {
+	plaintextType
+}
+
+type syslogOptions struct {
+	fileOptions `yaml:",inline"`
+	// Payload is either raw (the original line) or json (parsed fields)
+	Payload string `yaml:"payload"`
+}
+
+func init() {
+	RegisterLogType("syslog", &syslogType{})
+}
+
+func (t *syslogType) ParseOptions(unmarshal func(interface{}) error) (
+	interface{}, error) {
+
+	options := &syslogOptions{}
+	if err := unmarshal(options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	switch options.Payload {
+	case "":
+		options.Payload = "raw"
+	case "raw", "json":
+	default:
+		return nil, fmt.Errorf("Unknown payload %q, use raw or json",
+			options.Payload)
+	}
+	return options, nil
+}
+
+func (t *syslogType) options(cfg *TopicConfig) *syslogOptions {
+	if options, ok := cfg.Options.(*syslogOptions); ok {
+		return options
+	}
+	return &syslogOptions{Payload: "raw"}
+}
+
+func (t *syslogType) FileMatch(name string, cfg *TopicConfig) string {
+	return t.options(cfg).fileMatch(name)
+}
+
+func (t *syslogType) Priority(cfg *TopicConfig) []string {
+	return t.options(cfg).priority()
+}
+
+func (t *syslogType) Decoder(name, id string, cfg *TopicConfig) string {
+	return `type = "SandboxDecoder"` + "\n" +
+		`filename = "kafkafeeder/syslog.lua"` + "\n" +
+		fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("type = %s\n", tomlString(id)) +
+		fmt.Sprintf("payload = %s", tomlString(t.options(cfg).Payload))
+}
+
+func (t *syslogType) Fields(cfg *TopicConfig) []string {
+	return []string{"facility", "severity", "hostname", "app", "pid",
+		"msgid", "timestamp", "message", "structured_data"}
+}
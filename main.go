@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docopt/docopt-go"
@@ -23,16 +26,20 @@ type KafkaFeeder struct {
 	logManager   *LogManager
 }
 
+// restoreCheckpoints copies checkpoints over journals. Log streams with
+// more destinations start from the least advanced one, so none of them
+// misses messages.
 func (k *KafkaFeeder) restoreCheckpoints() (err error) {
 	k.lgr.Infof("Restoring checkpoints")
 	var cmd *exec.Cmd
-	matches, err := filepath.Glob(k.cfg.CheckpointDir + "/*")
+	restore, err := LeastCheckpoints(k.cfg.CheckpointDir, k.lgr)
 	if err != nil {
 		return
 	}
-	for _, match := range matches {
+	for name, match := range restore {
 		// TODO possible use shutil-go library
-		cmd = exec.Command("cp", match, k.cfg.JournalDir)
+		cmd = exec.Command("cp", match, filepath.Join(k.cfg.JournalDir,
+			name))
 		if err = cmd.Run(); err != nil {
 			return
 		}
@@ -59,15 +66,15 @@ func (k *KafkaFeeder) Start() {
 	}
 
 	// init log manager
-	if k.logManager, err = NewLogManager(); err != nil {
+	if k.logManager, err = NewLogManager(&k.cfg.Hekad); err != nil {
 		k.lgr.Infof("Log Manager initialization error: %q", err)
 		goto shutdown
 	}
 
 	// init hekad
 	hekad, err = NewHekad(k.lgr.WithField("name", "HEKAD"),
-		&k.cfg.Hekad, k.logManager, k.shutdownChan, &k.workerWG,
-		k.ShutDown)
+		&k.cfg.Hekad, k.logManager, k.cfg.JournalDir, k.cfg.CheckpointDir,
+		k.shutdownChan, &k.workerWG, k.ShutDown)
 	if err != nil {
 		k.lgr.Infof("Hekad initialization error: %q", err)
 		goto shutdown
@@ -103,7 +110,8 @@ func (k *KafkaFeeder) Start() {
 
 	// init log cleaner
 	cleaner, err = NewLogCleaner(k.lgr.WithField("name", "CLEANER"),
-		&k.cfg.Cleaner, k.shutdownChan, &k.workerWG)
+		&k.cfg.Cleaner, k.cfg.CheckpointDir, k.cfg.LedgerDir, k.logManager,
+		k.shutdownChan, &k.workerWG)
 	if err != nil {
 		k.lgr.Infof("Cleaner initialization error: %q", err)
 		goto shutdown
@@ -130,6 +138,181 @@ func (k *KafkaFeeder) Stop() {
 	k.lgr.Infof("Bye")
 }
 
+// status prints counters of messages of all topics
+func status(lgr LOGGER, cfg *Config) error {
+	if cfg.Hekad.DashboardDir == "" {
+		return fmt.Errorf("Hekad dashboard_dir is not set")
+	}
+	report, err := ReadHekaReport(cfg.Hekad.ReportPath())
+	if err != nil {
+		return err
+	}
+	logManager, err := NewLogManager(&cfg.Hekad)
+	if err != nil {
+		return err
+	}
+	if err = DiscoverLogs(lgr, cfg.LogDir, logManager); err != nil {
+		return err
+	}
+	return WriteStatus(os.Stdout, Status(logManager, report))
+}
+
+// interrupted returns channel closed on SIGINT or SIGTERM, commands running
+// one-off pipelines stop by it
+func interrupted() <-chan struct{} {
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+	return stop
+}
+
+// deadLetters returns dead-letter spools, only of topic if it is not empty
+func deadLetters(cfg *Config, topic string) ([]*DeadLetters, error) {
+	if cfg.Hekad.DeadLetterDir == "" {
+		return nil, fmt.Errorf("Hekad dead_letter_dir is not set")
+	}
+	spools, err := ListDeadLetters(cfg.Hekad.DeadLetterDir,
+		cfg.Hekad.KafkaBrokers)
+	if err != nil || topic == "" {
+		return spools, err
+	}
+	var selected []*DeadLetters
+	for _, spool := range spools {
+		if spool.Topic == topic {
+			selected = append(selected, spool)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("There are no dead letters of topic %q", topic)
+	}
+	return selected, nil
+}
+
+// dlq lists, inspects or reinjects dead letters
+func dlq(lgr LOGGER, cfg *Config, args map[string]interface{}) error {
+	topic, _ := args["<topic>"].(string)
+	spools, err := deadLetters(cfg, topic)
+	if err != nil {
+		return err
+	}
+	switch {
+	case args["list"].(bool):
+		return WriteDeadLetters(os.Stdout, spools)
+	case args["inspect"].(bool):
+		for _, spool := range spools {
+			reasons, err := spool.Reasons()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s/%s\n", spool.Broker, spool.Topic)
+			if err = WriteDeadLetterReasons(os.Stdout, reasons); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	target := topic
+	if into, ok := args["--into"].(string); ok {
+		if err = validTopicName(into); err != nil {
+			return err
+		}
+		target = into
+	}
+	to := time.Now()
+	if value, ok := args["--to"].(string); ok {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("Invalid --to: %v", err)
+		}
+	}
+	stop := interrupted()
+	for _, spool := range spools {
+		files, err := spool.Reinject(lgr, &cfg.Hekad, target, to, stop)
+		lgr.Infof("Reinjected %d files of %s/%s into %s", files,
+			spool.Broker, spool.Topic, target)
+		if err != nil {
+			return fmt.Errorf("Error reinjecting %s/%s: %v", spool.Broker,
+				spool.Topic, err)
+		}
+	}
+	return nil
+}
+
+// drain ships everything which was not shipped yet and prints what was
+// shipped, it reports whether everything was delivered
+func drain(lgr LOGGER, cfg *Config) (bool, error) {
+	logManager, err := NewLogManager(&cfg.Hekad)
+	if err != nil {
+		return false, err
+	}
+	if err = DiscoverLogs(lgr, cfg.LogDir, logManager); err != nil {
+		return false, err
+	}
+	for path, err := range logManager.Errors() {
+		lgr.Warnf("Rejected %v: %v", path, err)
+	}
+	results, err := Drain(lgr, cfg, logManager, interrupted())
+	if writeErr := WriteDrainResults(os.Stdout, results); err == nil {
+		err = writeErr
+	}
+	return err == nil && Drained(results) && len(logManager.Errors()) == 0,
+		err
+}
+
+// ledger prints files recorded as delivered, ledgers are updated first
+func ledger(lgr LOGGER, cfg *Config, args map[string]interface{}) error {
+	if cfg.LedgerDir == "" {
+		return fmt.Errorf("ledger_dir is not set")
+	}
+	logManager, err := NewLogManager(&cfg.Hekad)
+	if err != nil {
+		return err
+	}
+	if err = DiscoverLogs(lgr, cfg.LogDir, logManager); err != nil {
+		return err
+	}
+	manifest, _ := args["--manifest"].(string)
+	topic, _ := args["--topic"].(string)
+	rows, err := LedgerRows(cfg.LedgerDir, cfg.CheckpointDir, logManager,
+		manifest, topic)
+	if err != nil {
+		return err
+	}
+	return WriteLedger(os.Stdout, rows)
+}
+
+// replay ships files of a topic in a time range once more
+func replay(lgr LOGGER, cfg *Config, args map[string]interface{}) error {
+	var (
+		times = make([]time.Time, 2)
+		err   error
+	)
+	for i, option := range []string{"--from", "--to"} {
+		times[i] = time.Now()
+		if value, ok := args[option].(string); ok {
+			if times[i], err = time.Parse(time.RFC3339, value); err != nil {
+				return fmt.Errorf("Invalid %s: %v", option, err)
+			}
+		}
+	}
+	into, _ := args["--into"].(string)
+	if into != "" {
+		if err = validTopicName(into); err != nil {
+			return err
+		}
+	}
+	files, err := Replay(lgr, &cfg.Hekad, args["--manifest"].(string),
+		args["--topic"].(string), times[0], times[1], into, interrupted())
+	for _, file := range files {
+		lgr.Infof("Replayed %q", file.Path)
+	}
+	lgr.Infof("Replayed %d files", len(files))
+	return err
+}
+
 func main() {
 	lgr := &logrus.Logger{
 		Out:       os.Stderr,
@@ -142,10 +325,26 @@ func main() {
 
 Usage:
     kafkafeeder -c <config_file>
+    kafkafeeder status -c <config_file>
+    kafkafeeder dlq list -c <config_file>
+    kafkafeeder dlq inspect <topic> -c <config_file>
+    kafkafeeder dlq reinject <topic> [--to=<time>] [--into=<topic>]
+                             -c <config_file>
+    kafkafeeder drain -c <config_file>
+    kafkafeeder ledger [--manifest=<path>] [--topic=<name>] -c <config_file>
+    kafkafeeder replay --manifest=<path> --topic=<name> --from=<time>
+                       [--to=<time>] [--into=<topic>] -c <config_file>
     kafkafeeder -h | --help
 
 Options:
     -c --config         configuration file
+    --to=<time>         end of reinjected or replayed records in RFC3339,
+                        now when it is not set
+    --manifest=<path>   kafkafeeder.yaml of the replayed or listed log
+    --topic=<name>      replayed or listed log or its Kafka topic
+    --from=<time>       start of replayed records in RFC3339
+    --into=<topic>      reinject or replay into other topic than the
+                        original one
     -h --help           Show this screen.`
 
 	var err error
@@ -168,6 +367,43 @@ Options:
 		lgr.Fatalf("Config was not loaded")
 	}
 
+	if args["status"].(bool) {
+		if err = status(lgr.WithField("name", "STATUS"), cfg); err != nil {
+			lgr.Fatalf("Error reading status %q", err)
+		}
+		return
+	}
+	if args["drain"].(bool) {
+		drained, err := drain(lgr.WithField("name", "DRAIN"), cfg)
+		if err != nil {
+			lgr.Errorf("Error draining %q", err)
+		}
+		if !drained {
+			os.Exit(1)
+		}
+		return
+	}
+	if args["ledger"].(bool) {
+		if err = ledger(lgr.WithField("name", "LEDGER"), cfg,
+			args); err != nil {
+			lgr.Fatalf("Error reading ledger %q", err)
+		}
+		return
+	}
+	if args["replay"].(bool) {
+		if err = replay(lgr.WithField("name", "REPLAY"), cfg,
+			args); err != nil {
+			lgr.Fatalf("Error replaying %q", err)
+		}
+		return
+	}
+	if args["dlq"].(bool) {
+		if err = dlq(lgr.WithField("name", "DLQ"), cfg, args); err != nil {
+			lgr.Fatalf("Error handling dead letters %q", err)
+		}
+		return
+	}
+
 	kafkalog_hook, err := kafkalog_logrus.NewKafkalogHook(
 		cfg.Logging.Component, cfg.Logging.Interval, cfg.Logging.Dir)
 	if err != nil {
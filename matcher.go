@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// matcherHeaders are message headers usable in heka message matchers and
+// whether they are compared with numbers
+var matcherHeaders = map[string]bool{
+	"Uuid":       false,
+	"Type":       false,
+	"Logger":     false,
+	"Payload":    false,
+	"EnvVersion": false,
+	"Hostname":   false,
+	"Timestamp":  true,
+	"Severity":   true,
+	"Pid":        true,
+}
+
+var (
+	matcherIdentRegexp  = regexp.MustCompile(`^[A-Za-z]+`)
+	matcherIndexRegexp  = regexp.MustCompile(`^\[\d+\]`)
+	matcherNumberRegexp = regexp.MustCompile(`^-?\d+(\.\d+)?`)
+)
+
+// matcherOperators are ordered so that longer operators are tried first
+var matcherOperators = []string{"==", "!=", ">=", "<=", "=~", "!~", ">", "<"}
+
+// matcherNode is a parsed heka message matcher
+type matcherNode interface {
+	String() string
+	// negate returns a matcher matching exactly the messages this one does
+	// not, heka matchers do not have a negation operator
+	negate() matcherNode
+}
+
+type matcherLogical struct {
+	op          string // && or ||
+	left, right matcherNode
+}
+
+func (n *matcherLogical) String() string {
+	return "(" + n.left.String() + " " + n.op + " " + n.right.String() + ")"
+}
+
+func (n *matcherLogical) negate() matcherNode {
+	op := "&&"
+	if n.op == "&&" {
+		op = "||"
+	}
+	return &matcherLogical{op: op, left: n.left.negate(),
+		right: n.right.negate()}
+}
+
+type matcherBool bool
+
+func (n matcherBool) String() string {
+	if n {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (n matcherBool) negate() matcherNode {
+	return !n
+}
+
+type matcherComparison struct {
+	variable string
+	field    bool
+	op       string
+	value    string
+}
+
+var negatedOperators = map[string]string{
+	"==": "!=", "!=": "==", ">": "<=", "<=": ">", "<": ">=", ">=": "<",
+	"=~": "!~", "!~": "=~",
+}
+
+func (n *matcherComparison) String() string {
+	return n.variable + " " + n.op + " " + n.value
+}
+
+func (n *matcherComparison) negate() matcherNode {
+	negated := &matcherComparison{variable: n.variable, field: n.field,
+		op: negatedOperators[n.op], value: n.value}
+	if !n.field || n.value == "NIL" {
+		return negated
+	}
+	// comparisons of missing fields are false for both operators
+	return &matcherLogical{op: "||", left: negated, right: &matcherComparison{
+		variable: n.variable, field: true, op: "==", value: "NIL"}}
+}
+
+// matcherParser parses heka message matchers:
+//
+//	expr       = and { "||" and }
+//	and        = primary { "&&" primary }
+//	primary    = "(" expr ")" | "TRUE" | "FALSE" | comparison
+//	comparison = variable operator value
+type matcherParser struct {
+	expr string
+	pos  int
+}
+
+func parseMatcher(expr string) (matcherNode, error) {
+	p := &matcherParser{expr: expr}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos:])
+	}
+	return node, nil
+}
+
+// validateMatcher checks that expr is a valid heka message matcher
+func validateMatcher(expr string) error {
+	_, err := parseMatcher(expr)
+	return err
+}
+
+// negateMatcher returns heka message matcher matching messages which expr
+// does not match
+func negateMatcher(expr string) (string, error) {
+	node, err := parseMatcher(expr)
+	if err != nil {
+		return "", err
+	}
+	return node.negate().String(), nil
+}
+
+func (p *matcherParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid matcher %q at %d: %s", p.expr, p.pos,
+		fmt.Sprintf(format, args...))
+}
+
+func (p *matcherParser) skipSpaces() {
+	for p.pos < len(p.expr) && strings.ContainsRune(" \t\n", rune(
+		p.expr[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes token if it follows
+func (p *matcherParser) accept(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.expr[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *matcherParser) parseOr() (matcherNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node = &matcherLogical{op: "||", left: node, right: right}
+	}
+	return node, nil
+}
+
+func (p *matcherParser) parseAnd() (matcherNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		node = &matcherLogical{op: "&&", left: node, right: right}
+	}
+	return node, nil
+}
+
+func (p *matcherParser) parsePrimary() (matcherNode, error) {
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+		return node, nil
+	}
+	if p.accept("TRUE") {
+		return matcherBool(true), nil
+	}
+	if p.accept("FALSE") {
+		return matcherBool(false), nil
+	}
+	return p.parseComparison()
+}
+
+func (p *matcherParser) parseComparison() (matcherNode, error) {
+	p.skipSpaces()
+	start := p.pos
+	ident := matcherIdentRegexp.FindString(p.expr[p.pos:])
+	if ident == "" {
+		return nil, p.errorf("expected a message variable")
+	}
+	numeric, header := matcherHeaders[ident]
+	isField := ident == "Fields"
+	if !header && !isField {
+		return nil, p.errorf("unknown message variable %q", ident)
+	}
+	p.pos += len(ident)
+	if isField {
+		if err := p.parseFieldName(); err != nil {
+			return nil, err
+		}
+	}
+	node := &matcherComparison{variable: p.expr[start:p.pos], field: isField}
+
+	p.skipSpaces()
+	for _, candidate := range matcherOperators {
+		if strings.HasPrefix(p.expr[p.pos:], candidate) {
+			node.op = candidate
+			break
+		}
+	}
+	if node.op == "" {
+		return nil, p.errorf("expected a comparison operator")
+	}
+	p.pos += len(node.op)
+	p.skipSpaces()
+	start = p.pos
+	if err := p.parseValue(node, ident, numeric, header); err != nil {
+		return nil, err
+	}
+	node.value = p.expr[start:p.pos]
+	return node, nil
+}
+
+func (p *matcherParser) parseValue(node *matcherComparison, ident string,
+	numeric, header bool) error {
+
+	if node.op == "=~" || node.op == "!~" {
+		return p.parseRegexp()
+	}
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "'") || strings.HasPrefix(rest, `"`):
+		if numeric && ident != "Timestamp" {
+			return p.errorf("%s has to be compared with a number", ident)
+		}
+		return p.parseString()
+	case matcherNumberRegexp.MatchString(rest):
+		if header && !numeric {
+			return p.errorf("%s has to be compared with a string", ident)
+		}
+		p.pos += len(matcherNumberRegexp.FindString(rest))
+		return nil
+	}
+	for _, literal := range []string{"NIL", "TRUE", "FALSE"} {
+		if strings.HasPrefix(rest, literal) {
+			if !node.field || (node.op != "==" && node.op != "!=") {
+				return p.errorf("%s can be only compared as equal to "+
+					"a field", literal)
+			}
+			p.pos += len(literal)
+			return nil
+		}
+	}
+	return p.errorf("expected a value")
+}
+
+// parseFieldName parses "[name]" with optional field and array indexes
+func (p *matcherParser) parseFieldName() error {
+	if !strings.HasPrefix(p.expr[p.pos:], "[") {
+		return p.errorf("expected [ after Fields")
+	}
+	end := strings.IndexByte(p.expr[p.pos:], ']')
+	if end < 2 {
+		return p.errorf("expected a field name")
+	}
+	p.pos += end + 1
+	for i := 0; i < 2; i++ {
+		index := matcherIndexRegexp.FindString(p.expr[p.pos:])
+		if index == "" {
+			break
+		}
+		p.pos += len(index)
+	}
+	return nil
+}
+
+func (p *matcherParser) parseString() error {
+	quote := p.expr[p.pos]
+	for i := p.pos + 1; i < len(p.expr); i++ {
+		switch p.expr[i] {
+		case '\\':
+			i++
+		case quote:
+			p.pos = i + 1
+			return nil
+		}
+	}
+	return p.errorf("unterminated string")
+}
+
+func (p *matcherParser) parseRegexp() error {
+	if !strings.HasPrefix(p.expr[p.pos:], "/") {
+		return p.errorf("expected a regular expression in /")
+	}
+	for i := p.pos + 1; i < len(p.expr); i++ {
+		switch p.expr[i] {
+		case '\\':
+			i++
+		case '/':
+			re := strings.Replace(p.expr[p.pos+1:i], `\/`, "/", -1)
+			if _, err := regexp.Compile(re); err != nil {
+				return p.errorf("%v", err)
+			}
+			p.pos = i + 1
+			return nil
+		}
+	}
+	return p.errorf("unterminated regular expression")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMatcher(t *testing.T) {
+	for _, valid := range []string{
+		"TRUE",
+		"Fields[level] == 'ERROR'",
+		`Fields[level] == "ERROR" || Fields[level] == 'FATAL'`,
+		"(Fields[tenant] != NIL && Severity <= 3) || Type == 'a\\'b'",
+		"Fields[count][0][1] > 10.5",
+		"Payload =~ /timeout|refused/ && Hostname !~ /^test\\//",
+		"Timestamp < 1466000000000000000",
+		"Fields[debug] == FALSE",
+	} {
+		assert.Nil(t, validateMatcher(valid), valid)
+	}
+	for _, invalid := range []string{
+		"",
+		"level == 'ERROR'",
+		"Fields[level] = 'ERROR'",
+		"Fields[] == 'ERROR'",
+		"Fields[level] == 'ERROR",
+		"(Fields[level] == 'ERROR'",
+		"Fields[level] == 'ERROR')",
+		"Fields[level] == 'ERROR' &&",
+		"Severity == 'high'",
+		"Hostname == 5",
+		"Payload =~ 'timeout'",
+		"Payload =~ /(/",
+		"Type == NIL",
+		"Fields[level] > NIL",
+	} {
+		assert.NotNil(t, validateMatcher(invalid), invalid)
+	}
+}
+
+func TestNegateMatcher(t *testing.T) {
+	for expr, expected := range map[string]string{
+		"TRUE":                     "FALSE",
+		"Type == 'a'":              "Type != 'a'",
+		"Severity > 3":             "Severity <= 3",
+		"Fields[level] == 'DEBUG'": "(Fields[level] != 'DEBUG' || " +
+			"Fields[level] == NIL)",
+		"Fields[level] != NIL": "Fields[level] == NIL",
+		"Payload =~ /a/ && (Severity < 3 || Hostname == 'h')": "(Payload !~ " +
+			"/a/ || (Severity >= 3 && Hostname != 'h'))",
+	} {
+		negated, err := negateMatcher(expr)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, negated, expected, expr)
+		assert.Nil(t, validateMatcher(negated), negated)
+	}
+	_, err := negateMatcher("Type = 'a'")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// oneOffPollInterval is how often a one-off pipeline checks its checkpoints
+const oneOffPollInterval = time.Second
+
+// defaultOneOffTimeout is how long a one-off pipeline waits for progress,
+// hekad saves checkpoints once per checkpoint_interval
+const defaultOneOffTimeout = 10 * time.Minute
+
+// OneOffPipeline is a separate hekad shipping a fixed set of files. It has
+// its own configuration, journals and checkpoints in a temporary directory,
+// so it does not disturb the running kafkafeeder.
+type OneOffPipeline struct {
+	lgr       LOGGER
+	cfg       *HekadConfig
+	dir       string
+	converter *Converter
+	streams   []oneOffStream
+	// timeout stops hekad which delivers nothing for so long
+	timeout time.Duration
+}
+
+// oneOffStream is shipped when checkpoints of all its destinations reach
+// end of its last file. Positions in the stream are offsets from start of
+// its first file, lengths of gzipped files are decompressed.
+type oneOffStream struct {
+	id           string
+	files        []*LogFile
+	links        []string
+	ends         []int64 // positions of ends of files
+	start        int64
+	destinations int
+}
+
+// StreamProgress is what was delivered of a stream of a one-off pipeline to
+// all its destinations
+type StreamProgress struct {
+	Files []*LogFile // delivered as a whole
+	Bytes int64
+}
+
+func NewOneOffPipeline(lgr LOGGER, cfg *HekadConfig) (*OneOffPipeline, error) {
+	converter, err := NewConverter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"conf", "logs", "cache"} {
+		if err = os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	timeout := cfg.OneOffTimeout
+	if timeout == 0 {
+		timeout = defaultOneOffTimeout
+	}
+	return &OneOffPipeline{
+		lgr:       lgr,
+		cfg:       cfg,
+		dir:       dir,
+		converter: converter,
+		timeout:   timeout,
+	}, nil
+}
+
+// AddStream ships files of log stream name in dir by topic configuration
+// cfg, files have to be ordered from the oldest
+func (p *OneOffPipeline) AddStream(name, dir string, files []*LogFile,
+	cfg *TopicConfig) error {
+
+	return p.AddStreamFrom(name, dir, files, cfg, nil)
+}
+
+// AddStreamFrom ships files like AddStream, starting at journal start in
+// one of the files when it is not nil. Filters are not applied, hekad saves
+// checkpoints only of sent messages, so a stream ending by filtered records
+// would never be shipped.
+func (p *OneOffPipeline) AddStreamFrom(name, dir string, files []*LogFile,
+	cfg *TopicConfig, start *Journal) error {
+
+	if len(files) == 0 {
+		return nil
+	}
+	unfiltered := *cfg
+	unfiltered.Filter = Filter{}
+	cfg = &unfiltered
+	// logstreamer reads symlinks to the files, so only the given files are
+	// shipped and their names still match file_match
+	streamDir := filepath.Join(p.dir, "logs", strconv.Itoa(len(p.streams)))
+	stream := oneOffStream{
+		id:           StreamId(streamDir, name),
+		files:        files,
+		destinations: len(cfg.Destinations),
+	}
+	var startLink string
+	var end int64
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file.Path)
+		if err != nil {
+			return err
+		}
+		target, err := filepath.Abs(file.Path)
+		if err != nil {
+			return err
+		}
+		link := filepath.Join(streamDir, rel)
+		if err = os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return err
+		}
+		if err = os.Symlink(target, link); err != nil {
+			return err
+		}
+		if start != nil && start.FileName == file.Path {
+			startLink = link
+			stream.start = end + start.Seek
+		}
+		length, err := readLength(file)
+		if err != nil {
+			return err
+		}
+		end += length
+		stream.links = append(stream.links, link)
+		stream.ends = append(stream.ends, end)
+	}
+	if startLink != "" {
+		journalDir := filepath.Join(p.dir, "cache", "logstreamer")
+		if err := os.MkdirAll(journalDir, 0755); err != nil {
+			return err
+		}
+		journal := *start
+		journal.FileName = startLink
+		if err := writeJournal(filepath.Join(journalDir,
+			JournalName(stream.id)), &journal); err != nil {
+			return err
+		}
+	}
+	conf, err := os.Create(filepath.Join(p.dir, "conf", stream.id+".toml"))
+	if err != nil {
+		return err
+	}
+	defer conf.Close()
+	if err = p.converter.ConvertTopic(name, streamDir, cfg, conf); err != nil {
+		return err
+	}
+	p.streams = append(p.streams, stream)
+	return nil
+}
+
+func (p *OneOffPipeline) writeMainConf() error {
+	conf := "[hekad]\n" +
+		"maxprocs = 1\n" +
+		fmt.Sprintf("base_dir = %s\n", tomlString(filepath.Join(p.dir,
+			"cache"))) +
+		fmt.Sprintf("max_message_size = %d\n", p.converter.maxMessageSize)
+	if p.cfg.ShareDir != "" {
+		conf += fmt.Sprintf("share_dir = %s\n", tomlString(p.cfg.ShareDir))
+	}
+	return ioutil.WriteFile(filepath.Join(p.dir, "conf", "hekad.toml"),
+		[]byte(conf), 0644)
+}
+
+// position returns position of checkpoint in the stream, a checkpoint out
+// of the stream is at its start
+func (s *oneOffStream) position(checkpoint *Journal) int64 {
+	for i, link := range s.links {
+		if link != checkpoint.FileName {
+			continue
+		}
+		if i == 0 {
+			return checkpoint.Seek
+		}
+		return s.ends[i-1] + checkpoint.Seek
+	}
+	return s.start
+}
+
+// Progress reports for streams in order they were added what was delivered
+// to all their destinations, streams without files are not added
+func (p *OneOffPipeline) Progress() []StreamProgress {
+	checkpointDir := filepath.Join(p.dir, "cache", "checkpoint")
+	progress := make([]StreamProgress, len(p.streams))
+	for i, stream := range p.streams {
+		checkpoints, err := ReadCheckpoints(checkpointDir, stream.id,
+			stream.destinations)
+		if err != nil {
+			// some destination did not deliver anything yet
+			continue
+		}
+		least := stream.ends[len(stream.ends)-1]
+		for _, checkpoint := range checkpoints {
+			if pos := stream.position(checkpoint); pos < least {
+				least = pos
+			}
+		}
+		if least < stream.start {
+			least = stream.start
+		}
+		for j, end := range stream.ends {
+			if end <= least {
+				progress[i].Files = stream.files[:j+1]
+			}
+		}
+		progress[i].Bytes = least - stream.start
+	}
+	return progress
+}
+
+// Shipped reports for streams in order they were added whether they were
+// delivered to all their destinations, streams without files are not added
+func (p *OneOffPipeline) Shipped() []bool {
+	progress := p.Progress()
+	shipped := make([]bool, len(p.streams))
+	for i, stream := range p.streams {
+		shipped[i] = len(progress[i].Files) == len(stream.files)
+	}
+	return shipped
+}
+
+// shipped checks whether all streams were delivered
+func (p *OneOffPipeline) shipped() bool {
+	for _, shipped := range p.Shipped() {
+		if !shipped {
+			return false
+		}
+	}
+	return true
+}
+
+// delivered returns bytes of all streams delivered to all destinations
+func (p *OneOffPipeline) delivered() (bytes int64) {
+	for _, progress := range p.Progress() {
+		bytes += progress.Bytes
+	}
+	return bytes
+}
+
+// Run starts hekad and waits until all streams are delivered to Kafka,
+// until stop is closed or until nothing is delivered for the timeout of
+// the pipeline. Hekad is stopped and an error returned when not everything
+// was delivered, see Progress for what was.
+func (p *OneOffPipeline) Run(stop <-chan struct{}) error {
+	if len(p.streams) == 0 {
+		return nil
+	}
+	if err := p.writeMainConf(); err != nil {
+		return err
+	}
+	cmd := exec.Command(p.cfg.BinPath, "-config", filepath.Join(p.dir, "conf"))
+	cmd.Stdout = &hekadOutputCatcher{
+		lgr: p.lgr.WithField("hekad", "stdout"),
+		err: false,
+	}
+	cmd.Stderr = &hekadOutputCatcher{
+		lgr: p.lgr.WithField("hekad", "stderr"),
+		err: true,
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Error starting hekad process %q", err)
+	}
+	// backlog leaves room for live logs of the running kafkafeeder
+	if p.cfg.BacklogNice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid,
+			*p.cfg.BacklogNice); err != nil {
+			p.lgr.Warnf("Error lowering priority of hekad process %q", err)
+		}
+	}
+	throttle := NewThrottle(p.lgr, p.cfg.BacklogEgress)
+	throttle.Start(cmd.Process.Pid)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	terminate := func() {
+		throttle.Stop()
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			cmd.Process.Kill()
+		}
+		<-exited
+	}
+	ticker := time.NewTicker(oneOffPollInterval)
+	defer ticker.Stop()
+	delivered, progressed := p.delivered(), time.Now()
+	for {
+		select {
+		case err := <-exited:
+			throttle.Stop()
+			return fmt.Errorf("Hekad exited prematurely: %v", err)
+		case <-stop:
+			terminate()
+			return errors.New("Interrupted before all files were shipped")
+		case now := <-ticker.C:
+			if p.shipped() {
+				terminate()
+				return nil
+			}
+			if bytes := p.delivered(); bytes != delivered {
+				delivered, progressed = bytes, now
+			} else if now.Sub(progressed) >= p.timeout {
+				terminate()
+				return fmt.Errorf("Nothing was delivered for %s, hekad was "+
+					"stopped", p.timeout)
+			}
+		}
+	}
+}
+
+// Close removes the temporary directory
+func (p *OneOffPipeline) Close() error {
+	return os.RemoveAll(p.dir)
+}
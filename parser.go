@@ -2,20 +2,321 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+const maxTopicNameLength = 249
+
+var topicNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._\-]+$`)
+
+// validTopicName checks name against Kafka topic naming rules
+func validTopicName(name string) error {
+	if name == "." || name == ".." {
+		return fmt.Errorf("Invalid topic name %q", name)
+	}
+	if len(name) > maxTopicNameLength {
+		return fmt.Errorf("Topic name %q is longer than %d characters",
+			name, maxTopicNameLength)
+	}
+	if !topicNameRegexp.MatchString(name) {
+		return fmt.Errorf("Topic name %q contains illegal characters, "+
+			"allowed are only [a-zA-Z0-9._-]", name)
+	}
+	return nil
+}
+
+// kafkafeederYamlRetention can be written either as a single length, e.g.
+// "retention: 7d", or as a map with max_age and max_size keys
+type kafkafeederYamlRetention struct {
+	MaxAge  string `yaml:"max_age"`
+	MaxSize string `yaml:"max_size"`
+}
+
+func (r *kafkafeederYamlRetention) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+
+	if err := unmarshal(&r.MaxAge); err == nil {
+		return nil
+	}
+	type plain kafkafeederYamlRetention
+	return unmarshal((*plain)(r))
+}
+
+// messageHeaders are names of heka message headers, they can not be used as
+// names of fields
+var messageHeaders = []string{"Uuid", "Type", "Logger", "Payload",
+	"EnvVersion", "Hostname", "Timestamp", "Severity", "Pid"}
+
+func validateFields(fields map[string]string) error {
+	for name := range fields {
+		if !tomlKeyRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid field name %q", name)
+		}
+		if oneOf(name, messageHeaders) {
+			return fmt.Errorf("Field name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// kafkafeederYamlPartitioning can be written either as a strategy, e.g.
+// "partitioning: random", or as a map with strategy and field keys
+type kafkafeederYamlPartitioning struct {
+	Strategy string `yaml:"strategy"`
+	Field    string `yaml:"field"`
+}
+
+func (p *kafkafeederYamlPartitioning) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+
+	if err := unmarshal(&p.Strategy); err == nil {
+		return nil
+	}
+	type plain kafkafeederYamlPartitioning
+	return unmarshal((*plain)(p))
+}
+
+func newPartitioning(kfYaml *kafkafeederYamlPartitioning) (
+	p Partitioning, err error) {
+
+	p.Strategy = kfYaml.Strategy
+	switch p.Strategy {
+	case PARTITIONING_AUTO, PARTITIONING_RANDOM, PARTITIONING_ROUND_ROBIN,
+		PARTITIONING_SOURCE:
+	case PARTITIONING_HASH:
+		p.Field = kfYaml.Field
+		if p.Field == "" {
+			p.Field = "key"
+		}
+		if !tomlKeyRegexp.MatchString(p.Field) {
+			return p, fmt.Errorf("Invalid partitioning field %q", p.Field)
+		}
+	case "fixed":
+		return p, errors.New("Fixed partitioning is not supported, " +
+			"KafkaOutput of hekad can not send to a chosen partition")
+	default:
+		return p, fmt.Errorf("Unknown partitioning %q", p.Strategy)
+	}
+	if kfYaml.Field != "" && p.Strategy != PARTITIONING_HASH {
+		return p, errors.New("Partitioning field can be used only with hash")
+	}
+	return p, nil
+}
+
+// defaultErrorTries is number of tries before a message is dropped or
+// diverted when on_error sets only the action
+const defaultErrorTries = 3
+
+// kafkafeederYamlOnError can be written either as an action, e.g.
+// "on_error: drop", or as a map with action and tries keys
+type kafkafeederYamlOnError struct {
+	Action string `yaml:"action"`
+	Tries  *int   `yaml:"tries"`
+}
+
+func (o *kafkafeederYamlOnError) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+
+	if err := unmarshal(&o.Action); err == nil {
+		return nil
+	}
+	type plain kafkafeederYamlOnError
+	return unmarshal((*plain)(o))
+}
+
+func newOnError(kfYaml *kafkafeederYamlOnError) (o OnError, err error) {
+	o.Action = kfYaml.Action
+	switch o.Action {
+	case "", ON_ERROR_RETRY:
+		if kfYaml.Tries != nil {
+			return o, errors.New("Retry on error can not limit tries")
+		}
+		return o, nil
+	case ON_ERROR_DROP, ON_ERROR_DIVERT:
+	default:
+		return o, fmt.Errorf("Unknown on_error action %q", o.Action)
+	}
+	o.Tries = defaultErrorTries
+	if kfYaml.Tries != nil {
+		o.Tries = *kfYaml.Tries
+	}
+	if o.Tries <= 0 {
+		return o, errors.New("On error tries have to be positive")
+	}
+	return o, nil
+}
+
+type kafkafeederYamlDestination struct {
+	Topic  string `yaml:"topic"`
+	Broker string `yaml:"broker"`
+	Ack    string `yaml:"ack"`
+}
+
+func parseAck(value string) (int, error) {
+	if value == "" {
+		return ACK_DISK_WRITE, nil
+	}
+	ack, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Unknown ack level")
+	}
+	if ack != ACK_DISK_WRITE && ack != ACK_MEMORY_WRITE && ack != ACK_DISABLED {
+		return 0, errors.New("Unknown ack level")
+	}
+	return ack, nil
+}
+
+func newDestinations(kfYaml []kafkafeederYamlDestination) (
+	[]Destination, error) {
+
+	destinations := make([]Destination, 0, len(kfYaml))
+	for i, dest := range kfYaml {
+		if err := validTopicName(dest.Topic); err != nil {
+			return nil, fmt.Errorf("Destination %d: %v", i+1, err)
+		}
+		if dest.Broker == "" {
+			return nil, fmt.Errorf("Destination %d: broker can not be empty",
+				i+1)
+		}
+		ack, err := parseAck(dest.Ack)
+		if err != nil {
+			return nil, fmt.Errorf("Destination %d: %v", i+1, err)
+		}
+		destinations = append(destinations, Destination{
+			Topic:  dest.Topic,
+			Broker: dest.Broker,
+			Ack:    ack,
+		})
+	}
+	return destinations, nil
+}
+
+type kafkafeederYamlRoute struct {
+	Match      string `yaml:"match"`
+	Topic      string `yaml:"topic"`
+	TopicField string `yaml:"topic_field"`
+	Broker     string `yaml:"broker"`
+	Ack        string `yaml:"ack"`
+}
+
+// newRoutes parses routes, they use broker of the topic by default
+func newRoutes(kfYaml []kafkafeederYamlRoute, broker string) (
+	[]Route, error) {
+
+	routes := make([]Route, 0, len(kfYaml))
+	for i, route := range kfYaml {
+		if route.Match == "" {
+			return nil, fmt.Errorf("Route %d: match can not be empty", i+1)
+		}
+		if err := validateMatcher(route.Match); err != nil {
+			return nil, fmt.Errorf("Route %d: %v", i+1, err)
+		}
+		switch {
+		case route.Topic != "" && route.TopicField != "":
+			return nil, fmt.Errorf("Route %d: topic and topic_field can "+
+				"not be used together", i+1)
+		case route.Topic != "":
+			if err := validTopicName(route.Topic); err != nil {
+				return nil, fmt.Errorf("Route %d: %v", i+1, err)
+			}
+		case route.TopicField != "":
+			if !tomlKeyRegexp.MatchString(route.TopicField) {
+				return nil, fmt.Errorf("Route %d: invalid topic_field %q",
+					i+1, route.TopicField)
+			}
+		default:
+			return nil, fmt.Errorf("Route %d: topic or topic_field has to "+
+				"be set", i+1)
+		}
+		if route.Broker == "" {
+			route.Broker = broker
+		}
+		ack, err := parseAck(route.Ack)
+		if err != nil {
+			return nil, fmt.Errorf("Route %d: %v", i+1, err)
+		}
+		routes = append(routes, Route{
+			Match:      route.Match,
+			Topic:      route.Topic,
+			TopicField: route.TopicField,
+			Broker:     route.Broker,
+			Ack:        ack,
+		})
+	}
+	return routes, nil
+}
+
+type kafkafeederYamlFilter struct {
+	Include string `yaml:"include"`
+	Exclude string `yaml:"exclude"`
+	Sample  string `yaml:"sample"`
+}
+
+func newFilter(kfYaml *kafkafeederYamlFilter) (f Filter, err error) {
+	for _, matcher := range []string{kfYaml.Include, kfYaml.Exclude} {
+		if matcher == "" {
+			continue
+		}
+		if err = validateMatcher(matcher); err != nil {
+			return
+		}
+	}
+	f.Include = kfYaml.Include
+	f.Exclude = kfYaml.Exclude
+	if kfYaml.Sample != "" {
+		f.Sample, err = strconv.ParseFloat(
+			strings.TrimSuffix(kfYaml.Sample, "%"), 64)
+		if err != nil || f.Sample <= 0 || f.Sample > 100 {
+			return f, fmt.Errorf("Sample has to be a percentage between 0 "+
+				"and 100, not %q", kfYaml.Sample)
+		}
+	}
+	return f, nil
+}
+
 type kafkafeederYamlTopic struct {
-	Topic     string `yaml:"topic"`
-	Type      string `yaml:"type"`
-	Broker    string `yaml:"broker"`
-	Retention string `yaml:"retention"`
-	Ack       string `yaml:"ack"`
+	Topic     string                   `yaml:"topic"`
+	Type      string                   `yaml:"type"`
+	Broker    string                   `yaml:"broker"`
+	Retention kafkafeederYamlRetention `yaml:"retention"`
+	Ack       string                   `yaml:"ack"`
+	Encoding  string                   `yaml:"encoding"`
+	Fields    map[string]string        `yaml:"fields"`
+
+	Partitioning kafkafeederYamlPartitioning  `yaml:"partitioning"`
+	Producer     ProducerConfig               `yaml:"producer"`
+	OnError      kafkafeederYamlOnError       `yaml:"on_error"`
+	Destinations []kafkafeederYamlDestination `yaml:"destinations"`
+	Routes       []kafkafeederYamlRoute       `yaml:"routes"`
+	Filter       kafkafeederYamlFilter        `yaml:"filter"`
+	Redact       []kafkafeederYamlRedact      `yaml:"redact"`
+	StartFrom    string                       `yaml:"start_from"`
+	// RateLimit is rejected, hekad can not limit a single topic
+	RateLimit interface{} `yaml:"rate_limit"`
+
+	// unmarshal decodes the topic section once more, log types use it to
+	// read their own options
+	unmarshal func(interface{}) error
 }
+
+func (t *kafkafeederYamlTopic) UnmarshalYAML(
+	unmarshal func(interface{}) error) error {
+
+	type plain kafkafeederYamlTopic
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+	t.unmarshal = unmarshal
+	return nil
+}
+
 type kafkafeederYaml struct {
 	Topics map[string]*kafkafeederYamlTopic `yaml:"topics"`
 }
@@ -24,44 +325,135 @@ func newTopicConfig(kfYaml *kafkafeederYamlTopic) (_ *TopicConfig, err error) {
 	if kfYaml.Topic == "" {
 		return nil, errors.New("Topic can not be empty")
 	}
+	if err = validTopicName(kfYaml.Topic); err != nil {
+		return
+	}
 	if kfYaml.Type == "" {
 		return nil, errors.New("Type can not be empty")
 	}
+	if kfYaml.RateLimit != nil {
+		return nil, errors.New("Rate limit of topics is not supported, " +
+			"hekad can not limit a single topic, cap its egress in the " +
+			"main configuration")
+	}
+	logType, ok := GetLogType(kfYaml.Type)
+	if !ok {
+		return nil, fmt.Errorf("Unknown type %q, supported types are %v",
+			kfYaml.Type, LogTypeNames())
+	}
+	if kfYaml.unmarshal == nil {
+		kfYaml.unmarshal = func(interface{}) error { return nil }
+	}
+	options, err := logType.ParseOptions(kfYaml.unmarshal)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s options: %v", kfYaml.Type, err)
+	}
 	if kfYaml.Broker == "" {
 		return nil, errors.New("Broker can not be empty")
 	}
 	var retention time.Duration
-	if kfYaml.Retention == "" {
+	if kfYaml.Retention.MaxAge == "" {
 		retention = -1 // just a negative value
 	} else {
-		if retention, err = time.ParseDuration(kfYaml.Retention); err != nil {
-			// if parsing fails, try to append "h"
-			if retention, err = time.ParseDuration(
-				kfYaml.Retention + "h"); err != nil {
-				return nil, errors.New("Invalid retention value")
-			}
+		if retention, err = ParseRetention(kfYaml.Retention.MaxAge); err != nil {
+			return nil, fmt.Errorf("Invalid retention value: %v", err)
 		}
 		if retention <= 0 {
 			return nil, errors.New("Retention have to be positive")
 		}
 	}
-	if kfYaml.Ack == "" {
-		kfYaml.Ack = "-1"
+	var retentionSize int64 = -1 // just a negative value
+	if kfYaml.Retention.MaxSize != "" {
+		if retentionSize, err = ParseSize(kfYaml.Retention.MaxSize); err != nil {
+			return nil, fmt.Errorf("Invalid retention size: %v", err)
+		}
+		if retentionSize <= 0 {
+			return nil, errors.New("Retention size have to be positive")
+		}
 	}
-	var ack int
-	if ack, err = strconv.Atoi(kfYaml.Ack); err != nil {
-		return nil, errors.New("Unknown ack level")
+	ack, err := parseAck(kfYaml.Ack)
+	if err != nil {
+		return
 	}
-	if ack != ACK_DISK_WRITE && ack != ACK_MEMORY_WRITE && ack != ACK_DISABLED {
-		return nil, errors.New("Unknown ack level")
+
+	if err = validateFields(kfYaml.Fields); err != nil {
+		return
+	}
+
+	partitioning, err := newPartitioning(&kfYaml.Partitioning)
+	if err != nil {
+		return
+	}
+
+	producer, err := newProducer(&kfYaml.Producer)
+	if err != nil {
+		return
+	}
+
+	onError, err := newOnError(&kfYaml.OnError)
+	if err != nil {
+		return
+	}
+
+	destinations, err := newDestinations(kfYaml.Destinations)
+	if err != nil {
+		return
+	}
+	seen := map[[2]string]bool{{kfYaml.Broker, kfYaml.Topic}: true}
+	for _, dest := range destinations {
+		if seen[[2]string{dest.Broker, dest.Topic}] {
+			return nil, fmt.Errorf("Topic %q in broker %q is a destination "+
+				"more times", dest.Topic, dest.Broker)
+		}
+		seen[[2]string{dest.Broker, dest.Topic}] = true
+	}
+
+	routes, err := newRoutes(kfYaml.Routes, kfYaml.Broker)
+	if err != nil {
+		return
+	}
+
+	filter, err := newFilter(&kfYaml.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %v", err)
+	}
+
+	redact, err := newRedactRules(kfYaml.Redact)
+	if err != nil {
+		return
+	}
+
+	startFrom, err := newStartFrom(kfYaml.StartFrom)
+	if err != nil {
+		return
+	}
+
+	switch kfYaml.Encoding {
+	case "":
+		kfYaml.Encoding = ENCODING_RAW
+	case ENCODING_RAW, ENCODING_JSON, ENCODING_PROTOBUF:
+	default:
+		return nil, fmt.Errorf("Unknown encoding %q", kfYaml.Encoding)
 	}
 
 	return &TopicConfig{
-		Topic:     kfYaml.Topic,
-		Type:      kfYaml.Type,
-		Broker:    kfYaml.Broker,
-		Retention: retention,
-		Ack:       ack,
+		Topic:         kfYaml.Topic,
+		Type:          kfYaml.Type,
+		Broker:        kfYaml.Broker,
+		Retention:     retention,
+		RetentionSize: retentionSize,
+		Ack:           ack,
+		Encoding:      kfYaml.Encoding,
+		Fields:        kfYaml.Fields,
+		Partitioning:  partitioning,
+		Producer:      producer,
+		OnError:       onError,
+		Destinations:  destinations,
+		Routes:        routes,
+		Filter:        filter,
+		Redact:        redact,
+		StartFrom:     startFrom,
+		Options:       options,
 	}, nil
 }
 
@@ -81,6 +473,72 @@ func newLogConfig(kfYaml *kafkafeederYaml) (cfg *LogConfig, err error) {
 	return
 }
 
+// validatePartitioning checks that hashed field is set to messages by
+// logstreamer, by the log type or as a static field
+func validatePartitioning(cfg *TopicConfig, logType LogType,
+	hekadCfg *HekadConfig) error {
+
+	if cfg.Partitioning.Strategy != PARTITIONING_HASH {
+		return nil
+	}
+	field := cfg.Partitioning.Field
+	if _, ok := mergeFields(hekadCfg.Fields, cfg.Fields)[field]; ok ||
+		field == sourceFileField {
+		return nil
+	}
+	typeFields, ok := logType.(LogTypeFields)
+	if !ok || oneOf(field, typeFields.Fields(cfg)) {
+		return nil
+	}
+	return fmt.Errorf("Partitioning field %q is not set by type %s, "+
+		"known fields are %v", field, cfg.Type, typeFields.Fields(cfg))
+}
+
+// Validate checks references from the log configuration into the global
+// configuration
+func (cfg *LogConfig) Validate(hekadCfg *HekadConfig) error {
+	for name, topicCfg := range cfg.Topics {
+		if _, ok := hekadCfg.KafkaBrokers[topicCfg.Broker]; !ok {
+			return fmt.Errorf("Topic %q: unknown broker %q", name,
+				topicCfg.Broker)
+		}
+		for _, dest := range topicCfg.Destinations {
+			if _, ok := hekadCfg.KafkaBrokers[dest.Broker]; !ok {
+				return fmt.Errorf("Topic %q: unknown broker %q", name,
+					dest.Broker)
+			}
+		}
+		for _, route := range topicCfg.Routes {
+			if _, ok := hekadCfg.KafkaBrokers[route.Broker]; !ok {
+				return fmt.Errorf("Topic %q: unknown broker %q", name,
+					route.Broker)
+			}
+		}
+		if topicCfg.OnError.Action == ON_ERROR_DIVERT {
+			if hekadCfg.DeadLetterDir == "" {
+				return fmt.Errorf("Topic %q: diverting needs "+
+					"dead_letter_dir in hekad configuration", name)
+			}
+			// spools are kept by topic
+			if routesByField(topicCfg.Routes) {
+				return fmt.Errorf("Topic %q: messages routed by "+
+					"topic_field can not be diverted", name)
+			}
+		}
+		logType, _ := GetLogType(topicCfg.Type)
+		if err := validatePartitioning(topicCfg, logType,
+			hekadCfg); err != nil {
+			return fmt.Errorf("Topic %q: %v", name, err)
+		}
+		if validator, ok := logType.(LogTypeValidator); ok {
+			if err := validator.Validate(topicCfg, hekadCfg); err != nil {
+				return fmt.Errorf("Topic %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
 func Parse(data []byte) (*LogConfig, error) {
 	kfYaml := &kafkafeederYaml{}
 	if err := yaml.Unmarshal(data, kfYaml); err != nil {
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,20 +17,20 @@ func TestParseString(t *testing.T) {
 topics:
   componenta:
     topic: TOPIC
-    type: TYPE
+    type: kafkalog
     broker: BROKER
     retention: 24h
     ack: -1
   componenta2:
     topic: TOPIC2
-    type: TYPE2
+    type: kafkalog
     broker: BROKER2
 `
 	cfg, err := Parse([]byte(data))
 	assert.Nil(t, err)
 
 	assert.Equal(t, cfg.Topics["componenta"].Topic, "TOPIC")
-	assert.Equal(t, cfg.Topics["componenta"].Type, "TYPE")
+	assert.Equal(t, cfg.Topics["componenta"].Type, "kafkalog")
 	assert.Equal(t, cfg.Topics["componenta"].Broker, "BROKER")
 	rtn, err := time.ParseDuration("24h")
 	assert.Nil(t, err)
@@ -33,10 +38,11 @@ topics:
 	assert.Equal(t, cfg.Topics["componenta"].Ack, -1)
 
 	assert.Equal(t, cfg.Topics["componenta2"].Topic, "TOPIC2")
-	assert.Equal(t, cfg.Topics["componenta2"].Type, "TYPE2")
+	assert.Equal(t, cfg.Topics["componenta2"].Type, "kafkalog")
 	assert.Equal(t, cfg.Topics["componenta2"].Broker, "BROKER2")
 	assert.True(t, cfg.Topics["componenta2"].Retention < 0)
 	assert.Equal(t, cfg.Topics["componenta2"].Ack, -1)
+	assert.Equal(t, cfg.Topics["componenta2"].Encoding, ENCODING_RAW)
 
 	assert.Equal(t, cfg.Directory, "")
 }
@@ -54,3 +60,710 @@ func TestParseFile(t *testing.T) {
 
 	assert.Equal(t, cfg.Directory, "")
 }
+
+func TestParseInvalidTopicName(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: "%s"
+    type: kafkalog
+    broker: BROKER
+`
+	for _, name := range []string{".", "..", "topic/name", "topic name",
+		strings.Repeat("a", 250)} {
+		_, err := Parse([]byte(fmt.Sprintf(data, name)))
+		assert.NotNil(t, err, name)
+	}
+	_, err := Parse([]byte(fmt.Sprintf(data, strings.Repeat("a", 249))))
+	assert.Nil(t, err)
+	_, err = Parse([]byte(fmt.Sprintf(data, "topic.name-1_2")))
+	assert.Nil(t, err)
+}
+
+func TestValidateBroker(t *testing.T) {
+	cfg, err := ParseFile("./tests/kafkafeeder.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate(&HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	}))
+	assert.NotNil(t, cfg.Validate(&HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka_dev": []string{"kafka1:9092"}},
+	}))
+}
+
+func TestParseRetention(t *testing.T) {
+	for str, expected := range map[string]time.Duration{
+		"24":    24 * time.Hour,
+		"90m":   90 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+		"1w3d":  10 * 24 * time.Hour,
+		"1.5d":  36 * time.Hour,
+	} {
+		rtn, err := ParseRetention(str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, rtn, str)
+	}
+	for _, str := range []string{"", "d", "7x", "7d-", "1ms", "100000w",
+		"15000w15000w", "1e30"} {
+		_, err := ParseRetention(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for str, expected := range map[string]int64{
+		"100":   100,
+		"100B":  100,
+		"2KB":   2048,
+		"20GB":  20 << 30,
+		"1.5M":  3 << 19,
+		"10 mb": 10 << 20,
+	} {
+		size, err := ParseSize(str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, size, str)
+	}
+	for _, str := range []string{"", "GB", "10XB", "-1GB", "8388608TB",
+		"99999999999999999999"} {
+		_, err := ParseSize(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for str, expected := range map[string]int64{
+		"100":     100,
+		"2KB/s":   2048,
+		"10 MB/s": 10 << 20,
+	} {
+		rate, err := ParseRate(str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, rate, str)
+	}
+	for _, str := range []string{"", "/s", "10XB/s", "10MB/h"} {
+		_, err := ParseRate(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestParseRetentionMap(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    retention:
+      max_age: 7d
+      max_size: 20GB
+  componenta2:
+    topic: TOPIC2
+    type: kafkalog
+    broker: BROKER2
+    retention:
+      max_size: 1GB
+  componenta3:
+    topic: TOPIC3
+    type: kafkalog
+    broker: BROKER3
+    retention: 48
+`
+	cfg, err := Parse([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Retention, 7*24*time.Hour)
+	assert.Equal(t, cfg.Topics["componenta"].RetentionSize, int64(20<<30))
+	assert.True(t, cfg.Topics["componenta2"].Retention < 0)
+	assert.Equal(t, cfg.Topics["componenta2"].RetentionSize, int64(1<<30))
+	assert.Equal(t, cfg.Topics["componenta3"].Retention, 48*time.Hour)
+	assert.True(t, cfg.Topics["componenta3"].RetentionSize < 0)
+}
+
+func TestParseUnknownType(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: TYPE
+    broker: BROKER
+`
+	_, err := Parse([]byte(data))
+	assert.NotNil(t, err)
+}
+
+func TestParsePlaintext(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: plaintext
+    broker: BROKER
+    file_match: 'access\.log\.?(?P<Index>\d+)?'
+    priority: ["^Index"]
+`
+	cfg, err := Parse([]byte(data))
+	assert.Nil(t, err)
+	options := cfg.Topics["componenta"].Options.(*plaintextOptions)
+	assert.Equal(t, options.FileMatch, `access\.log\.?(?P<Index>\d+)?`)
+	assert.Equal(t, options.Priority, []string{"^Index"})
+
+	for _, invalid := range []string{
+		"file_match: 'access(\\.log'",
+		"file_match: 'access\\.log'\n    priority: [Index]",
+		"priority: [Index]",
+	} {
+		_, err = Parse([]byte(`
+topics:
+  componenta:
+    topic: TOPIC
+    type: plaintext
+    broker: BROKER
+    ` + invalid + "\n"))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseJsonlines(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: jsonlines
+    broker: BROKER
+    key: user_id
+    malformed_topic: TOPIC-malformed
+`
+	cfg, err := Parse([]byte(data))
+	assert.Nil(t, err)
+	options := cfg.Topics["componenta"].Options.(*jsonlinesOptions)
+	assert.Equal(t, options.Key, "user_id")
+	assert.Equal(t, options.MalformedTopic, "TOPIC-malformed")
+
+	_, err = Parse([]byte(`
+topics:
+  componenta:
+    topic: TOPIC
+    type: jsonlines
+    broker: BROKER
+    malformed_topic: TOPIC/malformed
+`))
+	assert.NotNil(t, err)
+}
+
+func TestParseSyslog(t *testing.T) {
+	var data = `
+topics:
+  messages:
+    topic: TOPIC
+    type: syslog
+    broker: BROKER
+    file_match: 'messages'
+`
+	cfg, err := Parse([]byte(data))
+	assert.Nil(t, err)
+	options := cfg.Topics["messages"].Options.(*syslogOptions)
+	assert.Equal(t, options.Payload, "raw")
+
+	_, err = Parse([]byte(data + "    payload: xml\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseMultiline(t *testing.T) {
+	var data = `
+topics:
+  app:
+    topic: TOPIC
+    type: multiline
+    broker: kafka
+    start: '^\d{4}-\d{2}-\d{2} '
+`
+	cfg, err := Parse([]byte(data + "    max_size: 64KB\n"))
+	assert.Nil(t, err)
+	options := cfg.Topics["app"].Options.(*multilineOptions)
+	assert.Equal(t, options.maxSize, int64(65536))
+	hekadCfg := &HekadConfig{
+		KafkaBrokers:   map[string][]string{"kafka": []string{"kafka1:9092"}},
+		MaxMessageSize: 1 << 20,
+	}
+	assert.Nil(t, cfg.Validate(hekadCfg))
+
+	cfg, err = Parse([]byte(data + "    max_size: 2MB\n"))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+
+	_, err = Parse([]byte(data + "    max_size: 0\n"))
+	assert.NotNil(t, err)
+	for _, start := range []string{"'('", "''", `"it's"`} {
+		_, err = Parse([]byte(strings.Replace(data,
+			`'^\d{4}-\d{2}-\d{2} '`, start, 1)))
+		assert.NotNil(t, err, start)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	var data = `
+topics:
+  app:
+    topic: TOPIC
+    type: custom
+    broker: kafka
+    file_match: '(?P<Seq>\d+)-app\.log'
+`
+	cfg, err := Parse([]byte(data + "    priority: [Seq]\n"))
+	assert.Nil(t, err)
+	options := cfg.Topics["app"].Options.(*customOptions)
+	assert.Equal(t, options.Splitter, "line")
+	assert.Equal(t, options.Decoder, "payload")
+
+	cfg, err = Parse([]byte(data + "    priority: [Seq]\n" +
+		"    splitter: regex\n    delimiter: '\\n\\n'\n    decoder: kafkalog\n"))
+	assert.Nil(t, err)
+	options = cfg.Topics["app"].Options.(*customOptions)
+	assert.Equal(t, options.Splitter, "regex")
+	assert.Equal(t, options.Decoder, "kafkalog")
+
+	for _, invalid := range []string{
+		"",
+		"    priority: [Date]\n",
+		"    priority: [Seq]\n    splitter: xml\n",
+		"    priority: [Seq]\n    splitter: regex\n",
+		"    priority: [Seq]\n    delimiter: '\\n'\n",
+		"    priority: [Seq]\n    decoder: xml\n",
+		"    priority: [Seq]\n    key: id\n",
+	} {
+		_, err = Parse([]byte(data + invalid))
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = Parse([]byte(strings.Replace(data, "file_match", "xfile", 1)))
+	assert.NotNil(t, err)
+}
+
+func TestParseRotation(t *testing.T) {
+	var data = `
+topics:
+  access:
+    topic: TOPIC
+    type: plaintext
+    broker: kafka
+    rotation: logrotate
+`
+	cfg, err := Parse([]byte(data + "    file: access_log\n"))
+	assert.Nil(t, err)
+	options := cfg.Topics["access"].Options.(*plaintextOptions)
+	assert.Equal(t, options.File, "access_log")
+
+	for _, invalid := range []string{
+		"    file_match: 'access'\n",
+		"    file: ../access.log\n",
+	} {
+		_, err = Parse([]byte(data + invalid))
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = Parse([]byte(strings.Replace(data, "logrotate", "daily", 1)))
+	assert.NotNil(t, err)
+	_, err = Parse([]byte(strings.Replace(data, "rotation: logrotate",
+		"file: access.log", 1)))
+	assert.NotNil(t, err)
+}
+
+func TestParseLua(t *testing.T) {
+	shareDir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(shareDir)
+	assert.Nil(t, os.Mkdir(filepath.Join(shareDir, "lua_decoders"), 0755))
+	assert.Nil(t, ioutil.WriteFile(
+		filepath.Join(shareDir, "lua_decoders", "nginx_access.lua"),
+		[]byte("-- decoder"), 0644))
+
+	var data = `
+topics:
+  access:
+    topic: TOPIC
+    type: lua
+    broker: kafka
+    script: %s
+    config:
+      log_format: '$remote_addr'
+      max: 10
+`
+	hekadCfg := &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+		ShareDir:     shareDir,
+	}
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "nginx_access.lua")))
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate(hekadCfg))
+	options := cfg.Topics["access"].Options.(*luaOptions)
+	assert.Equal(t, options.Config["max"], 10)
+
+	cfg, err = Parse([]byte(fmt.Sprintf(data, "missing.lua")))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+
+	for _, invalid := range []string{"../nginx_access.lua", "nginx", "''"} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = Parse([]byte(fmt.Sprintf(data, "nginx_access.lua") +
+		"      type: other\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseEncoding(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    encoding: %s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "json")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Encoding, ENCODING_JSON)
+	_, err = Parse([]byte(fmt.Sprintf(data, "xml")))
+	assert.NotNil(t, err)
+}
+
+func TestParseFields(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    fields:
+      %s: value
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "owner")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Fields,
+		map[string]string{"owner": "value"})
+	for _, name := range []string{"Type", "Payload", "'a b'"} {
+		_, err = Parse([]byte(fmt.Sprintf(data, name)))
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestParsePartitioning(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: %s
+    broker: kafka
+    partitioning: %s
+`
+	hekadCfg := &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+		Fields:       map[string]string{"dc": "dc1"},
+	}
+	for _, valid := range [][2]string{
+		{"kafkalog", "random"},
+		{"kafkalog", "round-robin"},
+		{"kafkalog", "hash"},
+		{"kafkalog", "source"},
+		{"syslog", "{strategy: hash, field: hostname}"},
+		{"plaintext", "{strategy: hash, field: dc}"},
+		{"lua\n    script: any.lua", "{strategy: hash, field: any}"},
+		{"plaintext", "{strategy: hash, field: source_file}"},
+	} {
+		cfg, err := Parse([]byte(fmt.Sprintf(data, valid[0], valid[1])))
+		assert.Nil(t, err, valid[1])
+		assert.Nil(t, validatePartitioning(cfg.Topics["componenta"],
+			logTypes[cfg.Topics["componenta"].Type], hekadCfg), valid[1])
+	}
+
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "plaintext", "hash")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Partitioning,
+		Partitioning{Strategy: PARTITIONING_HASH, Field: "key"})
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+
+	_, err = Parse([]byte(fmt.Sprintf(data, "kafkalog",
+		"{strategy: fixed, partition: 0}")))
+	assert.EqualError(t, err, "Fixed partitioning is not supported, "+
+		"KafkaOutput of hekad can not send to a chosen partition")
+
+	for _, invalid := range []string{
+		"sticky",
+		"fixed",
+		"{strategy: random, field: key}",
+		"{strategy: hash, field: 'a b'}",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, "kafkalog", invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseProducer(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    producer:
+      %s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data,
+		"{max_buffered_bytes: 1MB, max_buffer_time: 500ms, "+
+			"compression: snappy}")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Producer, Producer{
+		MaxBufferedBytes: 1 << 20,
+		MaxBufferTime:    500 * time.Millisecond,
+		Compression:      COMPRESSION_SNAPPY,
+	})
+	for _, invalid := range []string{
+		"{max_buffered_bytes: 10}",
+		"{max_buffered_bytes: 1GB}",
+		"{max_buffer_time: 1h}",
+		"{max_buffer_time: 5}",
+		"{error_timeout: 1ms}",
+		"{checkpoint_interval: 0s}",
+		"{compression: zstd}",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+
+	_, err = Parse([]byte(fmt.Sprintf(data, "{compression: lz4}")))
+	assert.EqualError(t, err, "Compression lz4 is not supported, "+
+		"the Kafka client of hekad can not produce it")
+}
+
+func TestParseOnError(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: kafka
+    on_error: %s
+`
+	for value, expected := range map[string]OnError{
+		"retry":                      {Action: ON_ERROR_RETRY},
+		"drop":                       {Action: ON_ERROR_DROP, Tries: 3},
+		"{action: divert, tries: 5}": {Action: ON_ERROR_DIVERT, Tries: 5},
+	} {
+		cfg, err := Parse([]byte(fmt.Sprintf(data, value)))
+		assert.Nil(t, err, value)
+		assert.Equal(t, cfg.Topics["componenta"].OnError, expected, value)
+	}
+	cfg, err := Parse([]byte(strings.Replace(data, "on_error: %s", "", 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].OnError, OnError{})
+
+	for _, invalid := range []string{
+		"ignore",
+		"{action: retry, tries: 3}",
+		"{action: drop, tries: 0}",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+
+	// diverted messages need a spool of their topic
+	hekadCfg := &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(data, "divert")))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+	hekadCfg.DeadLetterDir = "/tmp/dead-letter"
+	assert.Nil(t, cfg.Validate(hekadCfg))
+	cfg, err = Parse([]byte(fmt.Sprintf(data, "divert\n    routes:\n"+
+		"      - match: TRUE\n        topic_field: tenant")))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+}
+
+func TestParseRateLimit(t *testing.T) {
+	_, err := Parse([]byte(`
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: kafka
+    rate_limit:
+      bytes: 1MB/s
+`))
+	assert.EqualError(t, err, "Rate limit of topics is not supported, "+
+		"hekad can not limit a single topic, cap its egress in the main "+
+		"configuration")
+}
+
+func TestParseDestinations(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: kafka
+    destinations:
+%s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, `
+      - topic: TOPIC
+        broker: kafka_new
+        ack: 1
+      - topic: AUDIT
+        broker: kafka`)))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Destinations, []Destination{
+		{Topic: "TOPIC", Broker: "kafka_new", Ack: ACK_MEMORY_WRITE},
+		{Topic: "AUDIT", Broker: "kafka", Ack: ACK_DISK_WRITE},
+	})
+	hekadCfg := &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	}
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+	hekadCfg.KafkaBrokers["kafka_new"] = []string{"kafka1.new:9092"}
+	assert.Nil(t, cfg.Validate(hekadCfg))
+
+	for _, invalid := range []string{
+		"      - topic: TOPIC\n        broker: kafka",
+		"      - topic: AUDIT",
+		"      - broker: kafka",
+		"      - topic: AUDIT\n        broker: kafka\n        ack: 2",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: kafka
+    routes:
+%s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, `
+      - match: "Fields[level] == 'ERROR'"
+        topic: errors
+        ack: 1
+      - match: TRUE
+        topic_field: tenant
+        broker: kafka_tenants`)))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Routes, []Route{{
+		Match:  "Fields[level] == 'ERROR'",
+		Topic:  "errors",
+		Broker: "kafka",
+		Ack:    ACK_MEMORY_WRITE,
+	}, {
+		Match:      "TRUE",
+		TopicField: "tenant",
+		Broker:     "kafka_tenants",
+		Ack:        ACK_DISK_WRITE,
+	}})
+	hekadCfg := &HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	}
+	assert.NotNil(t, cfg.Validate(hekadCfg))
+
+	for _, invalid := range []string{
+		"      - topic: errors",
+		"      - match: \"Fields[level] = 'ERROR'\"\n        topic: errors",
+		"      - match: TRUE",
+		"      - match: TRUE\n        topic: errors\n        topic_field: a",
+		"      - match: TRUE\n        topic_field: 'a b'",
+		"      - match: TRUE\n        topic: 'a b'",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    filter:
+      %s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "{include: 'Severity < 7', "+
+		"exclude: \"Payload =~ /healthcheck/\", sample: 12.5%}")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Filter, Filter{
+		Include: "Severity < 7",
+		Exclude: "Payload =~ /healthcheck/",
+		Sample:  12.5,
+	})
+	for _, invalid := range []string{
+		"{include: 'Severity = 7'}",
+		"{exclude: 'level == DEBUG'}",
+		"{sample: 0}",
+		"{sample: 150%}",
+		"{sample: half}",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseRedact(t *testing.T) {
+	var data = `
+topics:
+  componenta:
+    topic: TOPIC
+    type: kafkalog
+    broker: BROKER
+    redact:
+      - %s
+`
+	cfg, err := Parse([]byte(fmt.Sprintf(data, "{detector: email}\n"+
+		"      - {regex: 'user=\\w+', field: user, strategy: hash}\n"+
+		"      - {detector: card, strategy: drop}")))
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Topics["componenta"].Redact, []RedactRule{
+		{Detector: REDACT_EMAIL, Field: "Payload", Strategy: REDACT_MASK},
+		{Regex: `user=\w+`, Pattern: "user=[%w_]+", Field: "user",
+			Strategy: REDACT_HASH},
+		{Detector: REDACT_CARD, Field: "Payload", Strategy: REDACT_DROP},
+	})
+	for _, invalid := range []string{
+		"{}",
+		"{detector: phone}",
+		"{detector: email, regex: '@'}",
+		"{detector: email, strategy: encrypt}",
+		"{detector: email, field: 'a b'}",
+		"{regex: '(a|b)'}",
+		"{regex: '['}",
+	} {
+		_, err = Parse([]byte(fmt.Sprintf(data, invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestRegexToLuaPattern(t *testing.T) {
+	for regex, expected := range map[string]string{
+		`^\d{4}`:             "",
+		`a.b*c+d?`:           "a.b*c+d?",
+		`<.*?>`:              "<.->",
+		`^card: \d+$`:        "^card: %d+$",
+		`[^\s,]+`:            "[^%s,]+",
+		`[a-z\d_-]+@x\.com`:  "[a-z%d_%-]+@x%.com",
+		`\W\w%`:              "[^%w_][%w_]%%",
+		`a+?`:                "",
+		`a^b`:                "",
+		`\bword`:             "",
+		`secret-[[:alpha:]]`: "",
+	} {
+		pattern, err := regexToLuaPattern(regex)
+		if expected == "" {
+			assert.NotNil(t, err, regex)
+			continue
+		}
+		assert.Nil(t, err, regex)
+		assert.Equal(t, expected, pattern, regex)
+	}
+}
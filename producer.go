@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	COMPRESSION_NONE   = "none"
+	COMPRESSION_GZIP   = "gzip"
+	COMPRESSION_SNAPPY = "snappy"
+	// lz4 is known only to be rejected
+	COMPRESSION_LZ4 = "lz4"
+)
+
+// compressionCodecs are compression_codec values supported by KafkaOutput
+var compressionCodecs = map[string]string{
+	COMPRESSION_NONE:   "None",
+	COMPRESSION_GZIP:   "GZIP",
+	COMPRESSION_SNAPPY: "Snappy",
+}
+
+// ProducerConfig tunes Kafka producer, it is used both for defaults in the
+// main configuration and for overrides in kafkafeeder.yaml
+type ProducerConfig struct {
+	MaxBufferedBytes   string `yaml:"max_buffered_bytes"`
+	MaxBufferTime      string `yaml:"max_buffer_time"`
+	ErrorTimeout       string `yaml:"error_timeout"`
+	CheckpointInterval string `yaml:"checkpoint_interval"`
+	Compression        string `yaml:"compression"`
+}
+
+// Producer are settings of Kafka producer, zero values are unset
+type Producer struct {
+	MaxBufferedBytes   int64
+	MaxBufferTime      time.Duration
+	ErrorTimeout       time.Duration
+	CheckpointInterval time.Duration
+	Compression        string
+}
+
+var defaultProducer = Producer{
+	MaxBufferedBytes:   102400,
+	MaxBufferTime:      15 * time.Second,
+	ErrorTimeout:       10 * time.Second,
+	CheckpointInterval: 60 * time.Second,
+}
+
+func parseBoundedDuration(name, str string, min, max time.Duration) (
+	time.Duration, error) {
+
+	if str == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %v", name, err)
+	}
+	if d < min || d > max {
+		return 0, fmt.Errorf("%s has to be between %v and %v, not %v", name,
+			min, max, d)
+	}
+	return d, nil
+}
+
+func newProducer(cfg *ProducerConfig) (p Producer, err error) {
+	if cfg.MaxBufferedBytes != "" {
+		p.MaxBufferedBytes, err = ParseSize(cfg.MaxBufferedBytes)
+		if err != nil {
+			return p, fmt.Errorf("Invalid max_buffered_bytes: %v", err)
+		}
+		if p.MaxBufferedBytes < 1<<10 || p.MaxBufferedBytes > 100<<20 {
+			return p, fmt.Errorf("max_buffered_bytes has to be between 1KB "+
+				"and 100MB, not %d", p.MaxBufferedBytes)
+		}
+	}
+	p.MaxBufferTime, err = parseBoundedDuration("max_buffer_time",
+		cfg.MaxBufferTime, time.Millisecond, 10*time.Minute)
+	if err != nil {
+		return
+	}
+	p.ErrorTimeout, err = parseBoundedDuration("error_timeout",
+		cfg.ErrorTimeout, 100*time.Millisecond, time.Hour)
+	if err != nil {
+		return
+	}
+	p.CheckpointInterval, err = parseBoundedDuration("checkpoint_interval",
+		cfg.CheckpointInterval, time.Second, time.Hour)
+	if err != nil {
+		return
+	}
+	if cfg.Compression == COMPRESSION_LZ4 {
+		// sarama of hekad 0.11 predates Kafka 0.10 and its lz4 codec
+		return p, errors.New("Compression lz4 is not supported, " +
+			"the Kafka client of hekad can not produce it")
+	}
+	if _, ok := compressionCodecs[cfg.Compression]; !ok &&
+		cfg.Compression != "" {
+		return p, fmt.Errorf("Unknown compression %q", cfg.Compression)
+	}
+	p.Compression = cfg.Compression
+	return p, nil
+}
+
+// Merge returns p with values overridden by values set in other
+func (p Producer) Merge(other Producer) Producer {
+	if other.MaxBufferedBytes != 0 {
+		p.MaxBufferedBytes = other.MaxBufferedBytes
+	}
+	if other.MaxBufferTime != 0 {
+		p.MaxBufferTime = other.MaxBufferTime
+	}
+	if other.ErrorTimeout != 0 {
+		p.ErrorTimeout = other.ErrorTimeout
+	}
+	if other.CheckpointInterval != 0 {
+		p.CheckpointInterval = other.CheckpointInterval
+	}
+	if other.Compression != "" {
+		p.Compression = other.Compression
+	}
+	return p
+}
+
+func (p Producer) MaxBufferTimeMs() int64 {
+	return int64(p.MaxBufferTime / time.Millisecond)
+}
+
+func (p Producer) ErrorTimeoutMs() int64 {
+	return int64(p.ErrorTimeout / time.Millisecond)
+}
+
+func (p Producer) CheckpointIntervalSec() int64 {
+	return int64(p.CheckpointInterval / time.Second)
+}
+
+// CompressionCodec returns compression_codec of KafkaOutput
+func (p Producer) CompressionCodec() string {
+	return compressionCodecs[p.Compression]
+}
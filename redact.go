@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	REDACT_EMAIL = "email"
+	REDACT_CARD  = "card"
+	REDACT_TOKEN = "token"
+)
+
+const (
+	REDACT_MASK = "mask"
+	REDACT_HASH = "hash"
+	REDACT_DROP = "drop"
+)
+
+// RedactRule replaces sensitive data found by a built-in detector or by
+// a regular expression in the payload or in a field
+type RedactRule struct {
+	Detector string `json:"detector,omitempty"`
+	Regex    string `json:"-"`
+	// Pattern is Regex translated to Lua pattern
+	Pattern  string `json:"pattern,omitempty"`
+	Field    string `json:"field"`
+	Strategy string `json:"strategy"`
+}
+
+// kafkafeederYamlRedact is a redaction rule both in kafkafeeder.yaml and in
+// the main configuration
+type kafkafeederYamlRedact struct {
+	Detector string `yaml:"detector"`
+	Regex    string `yaml:"regex"`
+	Field    string `yaml:"field"`
+	Strategy string `yaml:"strategy"`
+}
+
+func newRedactRules(kfYaml []kafkafeederYamlRedact) ([]RedactRule, error) {
+	rules := make([]RedactRule, 0, len(kfYaml))
+	for i, rule := range kfYaml {
+		r, err := newRedactRule(&rule)
+		if err != nil {
+			return nil, fmt.Errorf("Redact rule %d: %v", i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRedactRule(kfYaml *kafkafeederYamlRedact) (r RedactRule, err error) {
+	switch {
+	case kfYaml.Detector != "" && kfYaml.Regex != "":
+		return r, errors.New("Detector and regex can not be used together")
+	case kfYaml.Detector != "":
+		if !oneOf(kfYaml.Detector, []string{REDACT_EMAIL, REDACT_CARD,
+			REDACT_TOKEN}) {
+			return r, fmt.Errorf("Unknown detector %q", kfYaml.Detector)
+		}
+		r.Detector = kfYaml.Detector
+	case kfYaml.Regex != "":
+		if r.Pattern, err = regexToLuaPattern(kfYaml.Regex); err != nil {
+			return
+		}
+		r.Regex = kfYaml.Regex
+	default:
+		return r, errors.New("Detector or regex has to be set")
+	}
+	r.Field = kfYaml.Field
+	if r.Field == "" {
+		r.Field = "Payload"
+	} else if r.Field != "Payload" && !tomlKeyRegexp.MatchString(r.Field) {
+		return r, fmt.Errorf("Invalid field %q", r.Field)
+	}
+	r.Strategy = kfYaml.Strategy
+	switch r.Strategy {
+	case "":
+		r.Strategy = REDACT_MASK
+	case REDACT_MASK, REDACT_HASH, REDACT_DROP:
+	default:
+		return r, fmt.Errorf("Unknown strategy %q", r.Strategy)
+	}
+	return r, nil
+}
+
+// luaMagic are characters which have to be escaped in Lua patterns
+const luaMagic = "^$()%.[]*+-?"
+
+// regexClasses are Lua equivalents of escaped regular expression classes,
+// usable both inside and outside of brackets
+var regexClasses = map[byte]string{
+	'd': "%d", 'D': "%D", 's': "%s", 'S': "%S", 'w': "%w_",
+}
+
+// regexToLuaPattern translates regular expression into Lua pattern, which
+// is used by hekad sandboxes. Only regular expressions without groups,
+// alternation and counted repetition can be translated.
+func regexToLuaPattern(regex string) (string, error) {
+	if _, err := regexp.Compile(regex); err != nil {
+		return "", fmt.Errorf("Invalid regex: %v", err)
+	}
+	unsupported := func(what string) error {
+		return fmt.Errorf("Invalid regex %q: %s is not supported", regex,
+			what)
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(regex); i++ {
+		c := regex[i]
+		switch {
+		case c == '(' || c == ')':
+			return "", unsupported("group")
+		case c == '|':
+			return "", unsupported("alternation")
+		case c == '{':
+			return "", unsupported("counted repetition")
+		case c == '^' && i == 0, c == '$' && i == len(regex)-1, c == '.':
+			b.WriteByte(c)
+		case c == '^' || c == '$':
+			return "", unsupported("anchor inside of regex")
+		case c == '*' || c == '+' || c == '?':
+			lazy := i+1 < len(regex) && regex[i+1] == '?'
+			switch {
+			case !lazy:
+				b.WriteByte(c)
+			case c == '*':
+				b.WriteByte('-')
+				i++
+			default:
+				return "", unsupported("lazy " + string(c))
+			}
+		case c == '\\':
+			i++
+			class, err := translateEscape(regex, i, false)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+		case c == '[':
+			end, class, err := translateBrackets(regex, i)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+			i = end
+		case strings.IndexByte(luaMagic, c) >= 0:
+			b.WriteByte('%')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// translateEscape translates escape sequence at regex[i] following
+// a backslash
+func translateEscape(regex string, i int, inBrackets bool) (string, error) {
+	c := regex[i]
+	if class, ok := regexClasses[c]; ok {
+		if c == 'w' && !inBrackets {
+			return "[" + class + "]", nil
+		}
+		return class, nil
+	}
+	if c == 'W' && !inBrackets {
+		return "[^%w_]", nil
+	}
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') {
+		return "", fmt.Errorf("Invalid regex %q: \\%c is not supported",
+			regex, c)
+	}
+	return "%" + string(c), nil
+}
+
+// translateBrackets translates character class starting at regex[start],
+// it returns index of its closing bracket
+func translateBrackets(regex string, start int) (int, string, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	i := start + 1
+	if i < len(regex) && regex[i] == '^' {
+		b.WriteByte('^')
+		i++
+	}
+	for first := true; i < len(regex); i, first = i+1, false {
+		c := regex[i]
+		switch {
+		case c == ']' && !first:
+			b.WriteByte(']')
+			return i, b.String(), nil
+		case c == '\\':
+			i++
+			class, err := translateEscape(regex, i, true)
+			if err != nil {
+				return 0, "", err
+			}
+			b.WriteString(class)
+		case c == '[' && i+1 < len(regex) && regex[i+1] == ':':
+			return 0, "", fmt.Errorf("Invalid regex %q: POSIX classes are "+
+				"not supported", regex)
+		case c == '-' && i > start+1 && i+1 < len(regex) && regex[i+1] != ']':
+			b.WriteByte('-')
+		case c == '%' || c == ']' || c == '[' || c == '-' || c == '^':
+			b.WriteByte('%')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, "", fmt.Errorf("Invalid regex %q: missing ]", regex)
+}
+
+// redactDecoder returns configuration of decoder section name applying
+// rules, salt is prepended to hashed values
+func redactDecoder(name string, rules []RedactRule, salt string) (
+	string, error) {
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	return `type = "SandboxDecoder"` + "\n" +
+		`filename = "kafkafeeder/redact.lua"` + "\n" +
+		fmt.Sprintf("[%s.config]\n", name) +
+		fmt.Sprintf("rules = %s\n", tomlString(string(data))) +
+		fmt.Sprintf("salt = %s", tomlString(salt)), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ReplayFiles returns files which contain records written between from and
+// to. A file holds records written since modification of the previous one,
+// files have to be ordered from the oldest.
+func ReplayFiles(files []*LogFile, from, to time.Time) []*LogFile {
+	var selected []*LogFile
+	for i, file := range files {
+		if file.Info.ModTime().Before(from) {
+			continue
+		}
+		if i > 0 && files[i-1].Info.ModTime().After(to) {
+			break
+		}
+		selected = append(selected, file)
+	}
+	return selected
+}
+
+// replayTopic returns name and configuration of topic of log, name is
+// either a name of the topic section or a Kafka topic
+func replayTopic(log *LogConfig, name string) (string, *TopicConfig, error) {
+	if cfg, ok := log.Topics[name]; ok {
+		return name, cfg, nil
+	}
+	var found string
+	for section, cfg := range log.Topics {
+		if cfg.Topic != name {
+			continue
+		}
+		if found != "" {
+			return "", nil, fmt.Errorf("Topic %q is used by logs %q and %q, "+
+				"choose one of them", name, found, section)
+		}
+		found = section
+	}
+	if found == "" {
+		return "", nil, fmt.Errorf("There is no topic %q", name)
+	}
+	return found, log.Topics[found], nil
+}
+
+// Replay ships files of topic of kafkafeeder at manifest with records
+// written between from and to once more, into the topic into if it is not
+// empty. Additional destinations and routes are not replayed. It returns
+// the replayed files, also when replaying fails.
+func Replay(lgr LOGGER, cfg *HekadConfig, manifest, topic string, from,
+	to time.Time, into string, stop <-chan struct{}) ([]*LogFile, error) {
+
+	log, err := ParseFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err = log.Validate(cfg); err != nil {
+		return nil, err
+	}
+	if log.Directory, err = filepath.Abs(filepath.Dir(manifest)); err != nil {
+		return nil, err
+	}
+	name, topicCfg, err := replayTopic(log, topic)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ListStreamFiles(log.Directory, name, topicCfg)
+	if err != nil {
+		return nil, err
+	}
+	files = ReplayFiles(files, from, to)
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	replayCfg := *topicCfg
+	replayCfg.Destinations = nil
+	replayCfg.Routes = nil
+	if into != "" {
+		replayCfg.Topic = into
+	}
+	pipeline, err := NewOneOffPipeline(lgr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer pipeline.Close()
+	if err = pipeline.AddStream(name, log.Directory, files,
+		&replayCfg); err != nil {
+		return nil, err
+	}
+	if err = pipeline.Run(stop); err != nil {
+		return pipeline.Progress()[0].Files, err
+	}
+	return files, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeTestLog(t, dir, "20160101_000000_1_UTC-name.szn", 100, 72*time.Hour)
+	writeTestLog(t, dir, "20160102_000000_1_UTC-name.szn", 100, 48*time.Hour)
+	writeTestLog(t, dir, "20160103_000000_1_UTC-name.szn", 100, 24*time.Hour)
+	writeTestLog(t, dir, "20160104_000000_1_UTC-name.szn", 100, 0)
+	kafkalog, _ := GetLogType("kafkalog")
+	files, err := ListLogFiles(dir, kafkalog.FileMatch("name", nil),
+		kafkalog.Priority(nil))
+	assert.Nil(t, err)
+
+	now := time.Now()
+	names := func(files []*LogFile) []string {
+		var names []string
+		for _, file := range files {
+			names = append(names, filepath.Base(file.Path))
+		}
+		return names
+	}
+	// records of the second day are in the file modified at its end
+	assert.Equal(t, []string{"20160102_000000_1_UTC-name.szn"},
+		names(ReplayFiles(files, now.Add(-60*time.Hour),
+			now.Add(-50*time.Hour))))
+	assert.Equal(t, []string{"20160103_000000_1_UTC-name.szn",
+		"20160104_000000_1_UTC-name.szn"},
+		names(ReplayFiles(files, now.Add(-36*time.Hour), now)))
+	assert.Empty(t, ReplayFiles(files, now.Add(time.Hour),
+		now.Add(2*time.Hour)))
+}
+
+func TestReplayTopic(t *testing.T) {
+	log := &LogConfig{Topics: map[string]*TopicConfig{
+		"a": &TopicConfig{Topic: "shared"},
+		"b": &TopicConfig{Topic: "shared"},
+		"c": &TopicConfig{Topic: "own"},
+	}}
+	name, _, err := replayTopic(log, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", name)
+	name, _, err = replayTopic(log, "own")
+	assert.Nil(t, err)
+	assert.Equal(t, "c", name)
+	_, _, err = replayTopic(log, "shared")
+	assert.NotNil(t, err)
+	_, _, err = replayTopic(log, "missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sandboxResult is a result of process_message of a sandbox script
+type sandboxResult struct {
+	Status   int                      `json:"status"`
+	Error    string                   `json:"error"`
+	Message  map[string]interface{}   `json:"message"`
+	Injected []map[string]interface{} `json:"injected"`
+}
+
+// runSandbox runs sandbox script of heka share dir by tests/lua/sandbox.lua
+// with config on messages. Tests are skipped when there is no Lua
+// interpreter.
+func runSandbox(t *testing.T, script string, config map[string]interface{},
+	messages ...map[string]interface{}) []sandboxResult {
+
+	var lua string
+	for _, name := range []string{"lua5.1", "lua"} {
+		if path, err := exec.LookPath(name); err == nil {
+			lua = path
+			break
+		}
+	}
+	if lua == "" {
+		t.Skip("Lua interpreter is not installed")
+	}
+	input, err := json.Marshal(map[string]interface{}{
+		"config":   config,
+		"messages": messages,
+	})
+	assert.Nil(t, err)
+	cmd := exec.Command(lua, filepath.Join("tests", "lua", "sandbox.lua"),
+		filepath.Join("heka", "share", "kafkafeeder", script))
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Error running %s: %v\n%s", script, err, stderr.String())
+	}
+	var results []sandboxResult
+	assert.Nil(t, json.Unmarshal(output, &results))
+	assert.Equal(t, len(results), len(messages))
+	return results
+}
+
+func payload(str string) map[string]interface{} {
+	return map[string]interface{}{"Payload": str}
+}
+
+func TestSyslogScript(t *testing.T) {
+	results := runSandbox(t, "syslog.lua", map[string]interface{}{
+		"type": "id",
+	},
+		payload("<34>1 2016-01-01T10:00:00.123Z host1 app1 123 ID47 "+
+			"[a b=\"c\"] rfc5424\n"),
+		payload("<13>Jan  1 10:00:00 host2 app2[456]: rfc3164"),
+		payload("Jan  1 10:00:00 host3 app3: no pri"),
+		payload("<14>2016-01-01T10:00:00+01:00 host4 app4[7]: rfc3339"),
+		payload("2016-01-01T10:00:00Z host5 app5: rfc3339 no pri"),
+		payload("not a syslog line"),
+	)
+
+	fields := func(i int) map[string]interface{} {
+		if len(results[i].Injected) != 1 {
+			return nil
+		}
+		return results[i].Injected[0]["Fields"].(map[string]interface{})
+	}
+	assert.Equal(t, fields(0), map[string]interface{}{
+		"facility":        float64(4),
+		"severity":        float64(2),
+		"timestamp":       "2016-01-01T10:00:00.123Z",
+		"hostname":        "host1",
+		"app":             "app1",
+		"pid":             "123",
+		"msgid":           "ID47",
+		"structured_data": "[a b=\"c\"]",
+		"message":         "rfc5424",
+	})
+	assert.Equal(t, results[0].Injected[0]["Type"], "id")
+	assert.Equal(t, results[0].Injected[0]["Hostname"], "host1")
+	assert.Equal(t, results[0].Injected[0]["Severity"], float64(2))
+	assert.Equal(t, fields(1), map[string]interface{}{
+		"facility":  float64(1),
+		"severity":  float64(5),
+		"timestamp": "Jan  1 10:00:00",
+		"hostname":  "host2",
+		"app":       "app2",
+		"pid":       "456",
+		"message":   "rfc3164",
+	})
+	assert.Equal(t, fields(2), map[string]interface{}{
+		"timestamp": "Jan  1 10:00:00",
+		"hostname":  "host3",
+		"app":       "app3",
+		"message":   "no pri",
+	})
+	assert.Equal(t, fields(3), map[string]interface{}{
+		"facility":  float64(1),
+		"severity":  float64(6),
+		"timestamp": "2016-01-01T10:00:00+01:00",
+		"hostname":  "host4",
+		"app":       "app4",
+		"pid":       "7",
+		"message":   "rfc3339",
+	})
+	assert.Equal(t, fields(4), map[string]interface{}{
+		"timestamp": "2016-01-01T10:00:00Z",
+		"hostname":  "host5",
+		"app":       "app5",
+		"message":   "rfc3339 no pri",
+	})
+	assert.Equal(t, results[5].Status, -1)
+	assert.Equal(t, len(results[5].Injected), 0)
+}
+
+// redactHash is hash of redact.lua
+func redactHash(data string) string {
+	var a, b uint64
+	for i := 0; i < len(data); i++ {
+		a = (a*31 + uint64(data[i])) % 4294967291
+		b = (b*131 + uint64(data[i])) % 4294967279
+	}
+	return fmt.Sprintf("%08x%08x", a, b)
+}
+
+func TestRedactScript(t *testing.T) {
+	rules, err := json.Marshal([]RedactRule{
+		{Detector: REDACT_EMAIL, Field: "Payload", Strategy: REDACT_HASH},
+		{Detector: REDACT_CARD, Field: "Payload", Strategy: REDACT_MASK},
+		{Detector: REDACT_TOKEN, Field: "Payload", Strategy: REDACT_MASK},
+		{Pattern: "session=%x+", Field: "Payload", Strategy: REDACT_MASK},
+		{Detector: REDACT_EMAIL, Field: "user", Strategy: REDACT_DROP},
+	})
+	assert.Nil(t, err)
+	results := runSandbox(t, "redact.lua", map[string]interface{}{
+		"rules": string(rules),
+		"salt":  "salt",
+	},
+		payload("from john.doe@example.com to john.doe@example.com"),
+		payload("card 4111111111111111, 4111 1111 1111 1111 or "+
+			"4111-1111-1111-1111 paid"),
+		payload("not cards 4111111111111112 2016-01-01 10:00:00 "+
+			"12 4111 1111 1111 1111"),
+		payload("Authorization: Bearer abc.def-123 token=t0k3n&"+
+			"password: \"secret\" api_key=k3y"),
+		payload("session=deadbeef ok"),
+		map[string]interface{}{
+			"Payload": "login",
+			"Fields": map[string]interface{}{
+				"user":  "user jane@example.com",
+				"other": "jane@example.com",
+			},
+		},
+	)
+
+	output := func(i int) interface{} {
+		return results[i].Message["Payload"]
+	}
+	hash := redactHash("saltjohn.doe@example.com")
+	assert.Equal(t, output(0), "from "+hash+" to "+hash)
+	assert.Equal(t, output(1), "card [REDACTED], [REDACTED] or [REDACTED] "+
+		"paid")
+	assert.Equal(t, output(2), "not cards 4111111111111112 2016-01-01 "+
+		"10:00:00 12 [REDACTED]")
+	// prefixes of tokens are kept
+	assert.Equal(t, output(3), "Authorization: Bearer [REDACTED] "+
+		"token=[REDACTED]&password: \"[REDACTED]\" api_key=[REDACTED]")
+	assert.Equal(t, output(4), "[REDACTED] ok")
+	// the payload is redacted by its own rules, fields are not
+	assert.Equal(t, output(5), "login")
+	assert.Equal(t, results[5].Message["Fields"], map[string]interface{}{
+		"user":  "",
+		"other": "jane@example.com",
+	})
+	for _, result := range results {
+		assert.Equal(t, result.Status, 0)
+		assert.Equal(t, len(result.Injected), 0)
+	}
+}
+
+func TestJsonlinesScript(t *testing.T) {
+	config := map[string]interface{}{
+		"type":           "id",
+		"key_field":      "user",
+		"malformed_type": "id-malformed",
+	}
+	messages := []map[string]interface{}{
+		payload(`{"user": "jane", "n": 1}`),
+		payload(`{"user": 42}`),
+		payload(`{"user": {"name": "jane"}}`),
+		payload(`not json`),
+	}
+	results := runSandbox(t, "jsonlines.lua", config, messages...)
+	for _, result := range results {
+		assert.Equal(t, result.Status, 0)
+		assert.Equal(t, len(result.Injected), 1)
+	}
+	// lines are kept, keys are strings
+	assert.Equal(t, results[0].Injected[0], map[string]interface{}{
+		"Type":    "id",
+		"Payload": `{"user": "jane", "n": 1}`,
+		"Fields":  map[string]interface{}{"key": "jane"},
+	})
+	assert.Equal(t, results[1].Injected[0]["Fields"],
+		map[string]interface{}{"key": "42"})
+	assert.Equal(t, results[2].Injected[0]["Fields"],
+		map[string]interface{}{})
+	assert.Equal(t, results[3].Injected[0], map[string]interface{}{
+		"Type":    "id-malformed",
+		"Payload": "not json",
+	})
+
+	// malformed lines are decoder failures without their type
+	delete(config, "malformed_type")
+	results = runSandbox(t, "jsonlines.lua", config, messages[3])
+	assert.Equal(t, results[0].Status, -1)
+	assert.Equal(t, results[0].Error, "malformed JSON")
+	assert.Equal(t, len(results[0].Injected), 0)
+}
+
+func TestMultilineScript(t *testing.T) {
+	results := runSandbox(t, "multiline.lua", map[string]interface{}{
+		"type":     "id",
+		"max_size": 10,
+	},
+		payload("2016-01-01 first\n  at line 1\n  at line 2"),
+		payload("2016-01-01"),
+	)
+	assert.Equal(t, results[0].Injected, []map[string]interface{}{
+		{"Type": "id", "Payload": "2016-01-01"},
+	})
+	assert.Equal(t, results[1].Injected, []map[string]interface{}{
+		{"Type": "id", "Payload": "2016-01-01"},
+	})
+
+	// records are not truncated without max_size
+	results = runSandbox(t, "multiline.lua", map[string]interface{}{
+		"type": "id",
+	}, payload("2016-01-01 first\n  at line 1"))
+	assert.Equal(t, results[0].Status, 0)
+	assert.Equal(t, results[0].Injected[0]["Payload"],
+		"2016-01-01 first\n  at line 1")
+}
+
+func TestJsonEnvelopeScript(t *testing.T) {
+	results := runSandbox(t, "json_envelope.lua", map[string]interface{}{
+		"stream": "access",
+	},
+		map[string]interface{}{
+			"Timestamp": 1451642400e9,
+			"Hostname":  "host1",
+			"Payload":   "GET /",
+			"Fields": map[string]interface{}{
+				"source_file": "/var/log/access.log",
+				"user":        "jane",
+				"status":      float64(200),
+			},
+		},
+		map[string]interface{}{
+			"Timestamp": 1451642400e9,
+			"Hostname":  "host1",
+			"Payload":   "GET /",
+		},
+	)
+	envelope := func(i int) map[string]interface{} {
+		assert.Equal(t, results[i].Status, 0)
+		assert.Equal(t, len(results[i].Injected), 1)
+		injected := results[i].Injected[0]
+		fields := injected["Fields"].(map[string]interface{})
+		assert.Equal(t, fields["payload_type"], "json")
+		var envelope map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(injected["Payload"].(string)),
+			&envelope))
+		return envelope
+	}
+	// the source file is not repeated in fields
+	assert.Equal(t, envelope(0), map[string]interface{}{
+		"timestamp": float64(1451642400000),
+		"host":      "host1",
+		"stream":    "access",
+		"source":    "/var/log/access.log",
+		"payload":   "GET /",
+		"fields": map[string]interface{}{
+			"user":   "jane",
+			"status": float64(200),
+		},
+	})
+	assert.Equal(t, envelope(1), map[string]interface{}{
+		"timestamp": float64(1451642400000),
+		"host":      "host1",
+		"stream":    "access",
+		"payload":   "GET /",
+		"fields":    map[string]interface{}{},
+	})
+}
+
+func TestOversizeScript(t *testing.T) {
+	results := runSandbox(t, "oversize.lua", map[string]interface{}{
+		"type":     "id-dead-letter",
+		"max_size": 10,
+	},
+		payload("0123456789"),
+		payload("012345678"),
+	)
+	// records filling the whole buffer of the splitter are dead letters
+	assert.Equal(t, results[0].Status, 0)
+	assert.Equal(t, results[0].Message, map[string]interface{}{
+		"Type":    "id-dead-letter",
+		"Payload": "0123456789",
+		"Fields": map[string]interface{}{
+			"dead_letter_reason": "record exceeds max_message_size 10 " +
+				"bytes and was truncated",
+		},
+	})
+	// the others fail, so that they are decoded by the next decoder
+	assert.Equal(t, results[1].Status, -1)
+	assert.Equal(t, results[1].Message, payload("012345678"))
+	for _, result := range results {
+		assert.Equal(t, len(result.Injected), 0)
+	}
+}
+
+func TestCountScript(t *testing.T) {
+	results := runSandbox(t, "count.lua", nil, payload("record"))
+	assert.Equal(t, results[0].Status, 0)
+	assert.Equal(t, results[0].Message, payload("record"))
+	assert.Equal(t, len(results[0].Injected), 0)
+}
+
+func TestDeadLetterReasonScript(t *testing.T) {
+	results := runSandbox(t, "dead_letter_reason.lua", nil,
+		map[string]interface{}{
+			"Timestamp": 1451642400e9,
+			"Payload":   "record",
+			"Fields": map[string]interface{}{
+				"dead_letter_reason": "kafka:\tmessage\r\ntoo large",
+			},
+		},
+		map[string]interface{}{
+			"Timestamp": 1451642400e9,
+			"Payload":   "record",
+		},
+	)
+	reason := func(i int) interface{} {
+		assert.Equal(t, results[i].Status, 0)
+		assert.Equal(t, len(results[i].Injected), 1)
+		fields := results[i].Injected[0]["Fields"].(map[string]interface{})
+		assert.Equal(t, fields["payload_type"], "txt")
+		return results[i].Injected[0]["Payload"]
+	}
+	// one line per record
+	assert.Equal(t, reason(0),
+		"2016-01-01T10:00:00Z\tkafka: message  too large\n")
+	assert.Equal(t, reason(1), "2016-01-01T10:00:00Z\tunknown\n")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	START_FROM_BEGINNING = "beginning"
+	START_FROM_NOW       = "now"
+)
+
+// seededSuffix is suffix of a file in the journal directory marking a log
+// stream which was already seeded
+const seededSuffix = ".seeded"
+
+// lineHashLen is length of the end of the last read record, which is hashed
+// in logstreamer journals
+const lineHashLen = 500
+
+// StartFrom is where a new log stream starts, it starts from the beginning
+// when zero. Files are skipped as a whole.
+type StartFrom struct {
+	Now    bool          // skip all existing data
+	Time   time.Time     // skip files modified before
+	MaxAge time.Duration // skip files older
+}
+
+func newStartFrom(str string) (s StartFrom, err error) {
+	switch str {
+	case "", START_FROM_BEGINNING:
+		return
+	case START_FROM_NOW:
+		s.Now = true
+		return
+	}
+	if s.Time, err = time.Parse(time.RFC3339, str); err == nil {
+		return
+	}
+	if s.MaxAge, err = ParseRetention(str); err != nil {
+		return s, fmt.Errorf("Invalid start_from %q: expected %s, %s, "+
+			"RFC3339 time or max age", str, START_FROM_BEGINNING,
+			START_FROM_NOW)
+	}
+	return
+}
+
+// IsBeginning reports whether nothing is skipped
+func (s StartFrom) IsBeginning() bool {
+	return !s.Now && s.Time.IsZero() && s.MaxAge == 0
+}
+
+// skips reports whether file modified at modTime is skipped at time now
+func (s StartFrom) skips(modTime, now time.Time) bool {
+	switch {
+	case s.Now:
+		return true
+	case !s.Time.IsZero():
+		return modTime.Before(s.Time)
+	case s.MaxAge != 0:
+		return modTime.Before(now.Add(-s.MaxAge))
+	}
+	return false
+}
+
+// fileJournal returns journal after limit bytes of file, or at its end when
+// limit is negative. Gzipped files are read decompressed as logstreamer does,
+// they are not written anymore, so the limit does not apply to them.
+func fileJournal(path string, limit int64) (*Journal, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rd io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		rd = gz
+	} else if limit >= 0 {
+		rd = io.LimitReader(file, limit)
+	}
+	journal := &Journal{FileName: path}
+	last := make([]byte, 0, 2*lineHashLen)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := rd.Read(buf)
+		journal.Seek += int64(n)
+		last = append(last, buf[:n]...)
+		if len(last) > lineHashLen {
+			last = append(last[:0], last[len(last)-lineHashLen:]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if journal.Seek > 0 {
+		journal.LastHash = fmt.Sprintf("%x", sha1.Sum(last))
+	}
+	return journal, nil
+}
+
+// readLength returns length of file as logstreamer reads it, gzipped files
+// are read decompressed
+func readLength(file *LogFile) (int64, error) {
+	if !strings.HasSuffix(file.Path, ".gz") {
+		return file.Info.Size(), nil
+	}
+	journal, err := fileJournal(file.Path, -1)
+	if err != nil {
+		return 0, err
+	}
+	return journal.Seek, nil
+}
+
+// writeJournal writes journal into path
+func writeJournal(path string, journal *Journal) error {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// SeedJournal positions a new log stream by its start_from. The journal and
+// checkpoints of all destinations are set at the end of the last skipped
+// file. A stream is seeded only once, later it would skip files
+// written meanwhile. It returns nil when the stream is not new or nothing is
+// skipped.
+func SeedJournal(journalDir, checkpointDir, dir, name string,
+	cfg *TopicConfig, now time.Time) (*Journal, error) {
+
+	if cfg.StartFrom.IsBeginning() {
+		return nil, nil
+	}
+	id := StreamId(dir, name)
+	marker := filepath.Join(journalDir, JournalName(id)+seededSuffix)
+	existing := []string{marker, filepath.Join(journalDir, JournalName(id))}
+	for i := 0; i <= len(cfg.Destinations); i++ {
+		existing = append(existing, filepath.Join(checkpointDir,
+			CheckpointName(id, i)))
+	}
+	for _, path := range existing {
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil
+		}
+	}
+
+	files, err := ListStreamFiles(dir, name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	skipped := 0
+	for skipped < len(files) && cfg.StartFrom.skips(
+		files[skipped].Info.ModTime(), now) {
+		skipped++
+	}
+	if skipped == 0 {
+		return nil, ioutil.WriteFile(marker, nil, 0644)
+	}
+	journal, err := fileJournal(files[skipped-1].Path, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range existing[1:] {
+		if err = writeJournal(path, journal); err != nil {
+			return nil, err
+		}
+	}
+	return journal, ioutil.WriteFile(marker, nil, 0644)
+}
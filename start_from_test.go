@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStartFrom(t *testing.T) {
+	for str, expected := range map[string]StartFrom{
+		"":          StartFrom{},
+		"beginning": StartFrom{},
+		"now":       StartFrom{Now: true},
+		"7d":        StartFrom{MaxAge: 7 * 24 * time.Hour},
+		"2016-01-02T00:00:00Z": StartFrom{
+			Time: time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := newStartFrom(str)
+		assert.Nil(t, err, str)
+		assert.Equal(t, expected, s, str)
+	}
+	for _, str := range []string{"end", "2016-01-02", "-1d"} {
+		_, err := newStartFrom(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestSeedJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logDir := filepath.Join(dir, "logs")
+	journalDir := filepath.Join(dir, "logstreamer")
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	for _, d := range []string{logDir, journalDir, checkpointDir} {
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	day := 24 * time.Hour
+	writeTestLog(t, logDir, "20160101_000000_1_UTC-name.szn", 100, 10*day)
+	writeTestLog(t, logDir, "20160102_000000_1_UTC-name.szn", 1000, 9*day)
+	writeTestLog(t, logDir, "20160103_000000_1_UTC-name.szn", 100, 0)
+	cfg := &TopicConfig{Type: "kafkalog",
+		Destinations: []Destination{{Topic: "copy"}}}
+	seed := func() (*Journal, error) {
+		return SeedJournal(journalDir, checkpointDir, logDir, "name", cfg,
+			time.Now())
+	}
+
+	journal, err := seed()
+	assert.Nil(t, err)
+	assert.Nil(t, journal)
+
+	// older files are skipped, the stream continues by the newest one
+	cfg.StartFrom = StartFrom{MaxAge: 5 * day}
+	journal, err = seed()
+	assert.Nil(t, err)
+	assert.Equal(t, &Journal{
+		Seek:     1000,
+		FileName: filepath.Join(logDir, "20160102_000000_1_UTC-name.szn"),
+		LastHash: fmt.Sprintf("%x", sha1.Sum(make([]byte, lineHashLen))),
+	}, journal)
+	id := StreamId(logDir, "name")
+	for _, path := range []string{
+		filepath.Join(journalDir, JournalName(id)),
+		filepath.Join(checkpointDir, CheckpointName(id, 0)),
+		filepath.Join(checkpointDir, CheckpointName(id, 1)),
+	} {
+		written, err := ReadJournal(path)
+		assert.Nil(t, err, path)
+		assert.Equal(t, journal, written, path)
+	}
+
+	// only new streams are seeded
+	cfg.StartFrom = StartFrom{Now: true}
+	journal, err = seed()
+	assert.Nil(t, err)
+	assert.Nil(t, journal)
+	for _, d := range []string{journalDir, checkpointDir} {
+		assert.Nil(t, os.RemoveAll(d))
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	journal, err = seed()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), journal.Seek)
+	assert.Equal(t, filepath.Join(logDir, "20160103_000000_1_UTC-name.szn"),
+		journal.FileName)
+
+	// a stream without skipped files is not seeded later, files written
+	// meanwhile are shipped
+	for _, d := range []string{journalDir, checkpointDir} {
+		assert.Nil(t, os.RemoveAll(d))
+		assert.Nil(t, os.Mkdir(d, 0755))
+	}
+	cfg.StartFrom = StartFrom{MaxAge: 20 * day}
+	journal, err = seed()
+	assert.Nil(t, err)
+	assert.Nil(t, journal)
+	cfg.StartFrom = StartFrom{Now: true}
+	journal, err = seed()
+	assert.Nil(t, err)
+	assert.Nil(t, journal)
+	_, err = os.Stat(filepath.Join(journalDir, JournalName(id)))
+	assert.True(t, os.IsNotExist(err))
+}
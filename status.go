@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"text/tabwriter"
+)
+
+// counters of KafkaOutput in hekad report
+const (
+	REPORT_SENT   = "ProcessMessageCount"
+	REPORT_FAILED = "ProcessMessageFailures"
+)
+
+// HekaReport is a report of hekad plugins written by DashboardOutput, only
+// outputs are read
+type HekaReport struct {
+	Outputs []map[string]interface{} `json:"outputs"`
+}
+
+func ReadHekaReport(path string) (*HekaReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &HekaReport{}
+	if err = json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("Invalid hekad report %q: %v", path, err)
+	}
+	return report, nil
+}
+
+// Count returns value of counter of output plugin, zero when it is not
+// reported
+func (r *HekaReport) Count(plugin, counter string) int64 {
+	for _, output := range r.Outputs {
+		if output["Name"] != plugin {
+			continue
+		}
+		value, ok := output[counter].(map[string]interface{})
+		if !ok {
+			return 0
+		}
+		count, _ := value["value"].(float64)
+		return int64(count)
+	}
+	return 0
+}
+
+// TopicStatus are counters of messages of one destination or route of
+// a topic of a kafkafeeder
+type TopicStatus struct {
+	Path   string // path of kafkafeeder
+	Name   string
+	Broker string
+	Topic  string
+	Sent   int64
+	Failed int64
+	// Dropped and Diverted are messages failed by on_error policy, Diverted
+	// counts also records spooled for their size
+	Dropped  int64
+	Diverted int64
+	// Filtered and Sampled are messages of the topic not sent by filter,
+	// they are set only for the main destination
+	Filtered int64
+	Sampled  int64
+}
+
+// Status returns counters of all destinations of valid kafkafeeders sorted
+// by path and name
+func Status(logManager *LogManager, report *HekaReport) []TopicStatus {
+	var status []TopicStatus
+	logManager.Each(func(path string, logCfg *LogConfig) {
+		for name, topicCfg := range logCfg.Topics {
+			id := StreamId(logCfg.Directory, name)
+			destinations := append([]Destination{{
+				Topic:  topicCfg.Topic,
+				Broker: topicCfg.Broker,
+			}}, topicCfg.Destinations...)
+			ids := make([]string, len(destinations))
+			for i := range destinations {
+				ids[i] = OutputId(id, i)
+			}
+			for i, route := range topicCfg.Routes {
+				topic := route.Topic
+				if route.TopicField != "" {
+					topic = fmt.Sprintf("Fields[%s]", route.TopicField)
+				}
+				destinations = append(destinations, Destination{
+					Topic:  topic,
+					Broker: route.Broker,
+				})
+				ids = append(ids, RouteId(id, i+1))
+			}
+			for i, dest := range destinations {
+				output := "KafkaOutput_" + ids[i]
+				var filtered, sampled int64
+				if i == 0 {
+					filtered = report.Count("SandboxOutput_"+id+
+						filteredSuffix, REPORT_SENT)
+					sampled = report.Count("SandboxOutput_"+id+
+						sampledSuffix, REPORT_SENT)
+				}
+				dropped := report.Count("SandboxOutput_"+ids[i]+
+					droppedSuffix, REPORT_SENT)
+				diverted := report.Count("SandboxOutput_"+ids[i]+
+					deadLetterSuffix, REPORT_SENT)
+				status = append(status, TopicStatus{
+					Path:     path,
+					Name:     name,
+					Broker:   dest.Broker,
+					Topic:    dest.Topic,
+					Sent:     report.Count(output, REPORT_SENT),
+					Failed:   report.Count(output, REPORT_FAILED),
+					Dropped:  dropped,
+					Diverted: diverted,
+					Filtered: filtered,
+					Sampled:  sampled,
+				})
+			}
+		}
+	})
+	// destinations of a topic stay in order
+	sort.Stable(topicStatusSorter(status))
+	return status
+}
+
+type topicStatusSorter []TopicStatus
+
+func (s topicStatusSorter) Len() int      { return len(s) }
+func (s topicStatusSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s topicStatusSorter) Less(i, j int) bool {
+	if s[i].Path != s[j].Path {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Name < s[j].Name
+}
+
+func WriteStatus(wr io.Writer, status []TopicStatus) error {
+	tw := tabwriter.NewWriter(wr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KAFKAFEEDER\tNAME\tBROKER\tTOPIC\tSENT\tFAILED\t"+
+		"DROPPED\tDIVERTED\tFILTERED\tSAMPLED")
+	for _, s := range status {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			s.Path, s.Name, s.Broker, s.Topic, s.Sent, s.Failed, s.Dropped,
+			s.Diverted, s.Filtered, s.Sampled)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	reportPath := filepath.Join(dir, "heka_report.json")
+	assert.Nil(t, ioutil.WriteFile(reportPath, []byte(`{
+"outputs": [
+  {"Name": "KafkaOutput_`+StreamId(dir, "events")+`",
+   "ProcessMessageCount": {"value": 10, "representation": "count"},
+   "ProcessMessageFailures": {"value": 3, "representation": "count"}},
+  {"Name": "SandboxOutput_`+StreamId(dir, "events")+`-filtered",
+   "ProcessMessageCount": {"value": 4, "representation": "count"}},
+  {"Name": "KafkaOutput_`+StreamId(dir, "events")+`@1",
+   "ProcessMessageCount": {"value": 7, "representation": "count"}},
+  {"Name": "SandboxOutput_`+StreamId(dir, "events")+`@1-dropped",
+   "ProcessMessageCount": {"value": 2, "representation": "count"}},
+  {"Name": "SandboxOutput_`+StreamId(dir, "events")+`-dead-letter",
+   "ProcessMessageCount": {"value": 1, "representation": "count"}},
+  {"Name": "DashboardOutput"}
+]}`), 0644))
+	report, err := ReadHekaReport(reportPath)
+	assert.Nil(t, err)
+
+	manifest := filepath.Join(dir, "kafkafeeder.yaml")
+	assert.Nil(t, ioutil.WriteFile(manifest, []byte(`
+topics:
+  events:
+    topic: events
+    type: kafkalog
+    broker: kafka
+    destinations:
+      - topic: events-copy
+        broker: kafka
+  access:
+    topic: access
+    type: kafkalog
+    broker: kafka
+`), 0644))
+	info, err := os.Stat(manifest)
+	assert.Nil(t, err)
+	logManager, err := NewLogManager(&HekadConfig{
+		KafkaBrokers: map[string][]string{"kafka": []string{"kafka1:9092"}},
+	})
+	assert.Nil(t, err)
+	_, err = logManager.Add(manifest, manifest, info)
+	assert.Nil(t, err)
+
+	status := Status(logManager, report)
+	assert.Equal(t, status, []TopicStatus{
+		{Path: manifest, Name: "access", Broker: "kafka", Topic: "access"},
+		{Path: manifest, Name: "events", Broker: "kafka", Topic: "events",
+			Sent: 10, Failed: 3, Diverted: 1, Filtered: 4},
+		{Path: manifest, Name: "events", Broker: "kafka",
+			Topic: "events-copy", Sent: 7, Dropped: 2},
+	})
+
+	var b bytes.Buffer
+	assert.Nil(t, WriteStatus(&b, status))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Equal(t, len(lines), 4)
+	assert.Equal(t, strings.Fields(lines[2])[3:],
+		[]string{"events", "10", "3", "0", "1", "4", "0"})
+}
+
+func TestReportPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &HekadConfig{
+		DashboardDir: filepath.Join(dir, "dashboard"),
+		ShareDir:     "/usr/share/heka",
+	}
+	c, err := NewConverter(cfg)
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	assert.Nil(t, c.ConvertDashboard(&b))
+	assert.Equal(t, b.String(), `[DashboardOutput]
+address = "0.0.0.0:8796"
+working_directory = "`+cfg.DashboardDir+`"
+static_directory = "/usr/share/heka/dasher"
+`)
+
+	// DashboardOutput writes the report into data of its working directory
+	workDir := regexp.MustCompile(`working_directory = "(.*)"`).
+		FindStringSubmatch(b.String())[1]
+	assert.Nil(t, os.MkdirAll(filepath.Join(workDir, "data"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(workDir, "data",
+		"heka_report.json"), []byte(`{"outputs": [{"Name": "a",
+"ProcessMessageCount": {"value": 1}}]}`), 0644))
+	report, err := ReadHekaReport(cfg.ReportPath())
+	assert.Nil(t, err)
+	assert.Equal(t, report.Count("a", REPORT_SENT), int64(1))
+
+	// without the directory hekad runs no dashboard
+	cfg.DashboardDir = ""
+	b.Reset()
+	assert.Nil(t, c.ConvertDashboard(&b))
+	assert.Equal(t, b.String(), "")
+}
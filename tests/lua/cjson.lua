@@ -0,0 +1,213 @@
+--[[
+Pure Lua replacement of the lua-cjson functions used by sandbox scripts, so
+that the scripts can be tested by a plain Lua 5.1 interpreter.
+
+Tables with metatable field __jsontype = "array" are encoded as arrays even
+when they are empty, decoded arrays have it set.
+--]]
+
+cjson = {}
+
+cjson.null = setmetatable({}, {__tostring = function() return "null" end})
+
+local ARRAY = {__jsontype = "array"}
+
+local escapes = {
+    ['"'] = '\\"', ["\\"] = "\\\\", ["\b"] = "\\b", ["\f"] = "\\f",
+    ["\n"] = "\\n", ["\r"] = "\\r", ["\t"] = "\\t"
+}
+
+local function encode_string(s)
+    return '"' .. string.gsub(s, '[%c"\\]', function(c)
+        return escapes[c] or string.format("\\u%04x", string.byte(c))
+    end) .. '"'
+end
+
+local function is_array(t)
+    local mt = getmetatable(t)
+    if mt and mt.__jsontype then
+        return mt.__jsontype == "array"
+    end
+    local n = 0
+    for _ in pairs(t) do
+        n = n + 1
+    end
+    return n > 0 and n == #t
+end
+
+local function encode(value)
+    local t = type(value)
+    if value == nil or value == cjson.null then
+        return "null"
+    elseif t == "boolean" then
+        return tostring(value)
+    elseif t == "number" then
+        if value ~= value or value == math.huge or value == -math.huge then
+            error("Cannot serialise number: must not be NaN or Inf")
+        end
+        if value == math.floor(value) and math.abs(value) < 2^53 then
+            return string.format("%d", value)
+        end
+        return string.format("%.17g", value)
+    elseif t == "string" then
+        return encode_string(value)
+    elseif t == "table" then
+        local parts = {}
+        if is_array(value) then
+            for i = 1, #value do
+                parts[i] = encode(value[i])
+            end
+            return "[" .. table.concat(parts, ",") .. "]"
+        end
+        for k, v in pairs(value) do
+            parts[#parts + 1] = encode_string(tostring(k)) .. ":" .. encode(v)
+        end
+        return "{" .. table.concat(parts, ",") .. "}"
+    end
+    error("Cannot serialise " .. t)
+end
+
+function cjson.encode(value)
+    return encode(value)
+end
+
+local function decode_error(pos, expected)
+    error(string.format("Expected %s at character %d", expected, pos), 0)
+end
+
+local function skip(str, pos)
+    return string.find(str, "[^ \t\r\n]", pos) or #str + 1
+end
+
+local function utf8(code)
+    if code < 0x80 then
+        return string.char(code)
+    elseif code < 0x800 then
+        return string.char(0xc0 + math.floor(code / 0x40),
+                           0x80 + code % 0x40)
+    end
+    return string.char(0xe0 + math.floor(code / 0x1000),
+                       0x80 + math.floor(code / 0x40) % 0x40,
+                       0x80 + code % 0x40)
+end
+
+local unescapes = {
+    b = "\b", f = "\f", n = "\n", r = "\r", t = "\t",
+    ['"'] = '"', ["\\"] = "\\", ["/"] = "/"
+}
+
+local function decode_string(str, pos)
+    local parts = {}
+    local i = pos + 1
+    while true do
+        local c = string.sub(str, i, i)
+        if c == "" then
+            decode_error(i, "closing quote")
+        elseif c == '"' then
+            return table.concat(parts), i + 1
+        elseif c == "\\" then
+            local e = string.sub(str, i + 1, i + 1)
+            if unescapes[e] then
+                parts[#parts + 1] = unescapes[e]
+                i = i + 2
+            elseif e == "u" then
+                local code = tonumber(string.sub(str, i + 2, i + 5), 16)
+                if not code then
+                    decode_error(i, "unicode escape")
+                end
+                parts[#parts + 1] = utf8(code)
+                i = i + 6
+            else
+                decode_error(i, "escape sequence")
+            end
+        else
+            parts[#parts + 1] = c
+            i = i + 1
+        end
+    end
+end
+
+local decode_value
+
+local function decode_array(str, pos)
+    local array = setmetatable({}, ARRAY)
+    pos = skip(str, pos + 1)
+    if string.sub(str, pos, pos) == "]" then
+        return array, pos + 1
+    end
+    while true do
+        array[#array + 1], pos = decode_value(str, pos)
+        pos = skip(str, pos)
+        local c = string.sub(str, pos, pos)
+        if c == "]" then
+            return array, pos + 1
+        elseif c ~= "," then
+            decode_error(pos, "comma or array end")
+        end
+        pos = skip(str, pos + 1)
+    end
+end
+
+local function decode_object(str, pos)
+    local object = {}
+    pos = skip(str, pos + 1)
+    if string.sub(str, pos, pos) == "}" then
+        return object, pos + 1
+    end
+    while true do
+        if string.sub(str, pos, pos) ~= '"' then
+            decode_error(pos, "object key string")
+        end
+        local key
+        key, pos = decode_string(str, pos)
+        pos = skip(str, pos)
+        if string.sub(str, pos, pos) ~= ":" then
+            decode_error(pos, "colon")
+        end
+        object[key], pos = decode_value(str, skip(str, pos + 1))
+        pos = skip(str, pos)
+        local c = string.sub(str, pos, pos)
+        if c == "}" then
+            return object, pos + 1
+        elseif c ~= "," then
+            decode_error(pos, "comma or object end")
+        end
+        pos = skip(str, pos + 1)
+    end
+end
+
+local literals = {["true"] = true, ["false"] = false, null = cjson.null}
+
+function decode_value(str, pos)
+    local c = string.sub(str, pos, pos)
+    if c == "{" then
+        return decode_object(str, pos)
+    elseif c == "[" then
+        return decode_array(str, pos)
+    elseif c == '"' then
+        return decode_string(str, pos)
+    end
+    local number = string.match(str, "^-?%d+%.?%d*[eE]?[-+]?%d*", pos)
+    if number and tonumber(number) then
+        return tonumber(number), pos + #number
+    end
+    for literal, value in pairs(literals) do
+        if string.sub(str, pos, pos + #literal - 1) == literal then
+            return value, pos + #literal
+        end
+    end
+    decode_error(pos, "value")
+end
+
+function cjson.decode(str)
+    if type(str) ~= "string" then
+        error("Expected string argument")
+    end
+    local value, pos = decode_value(str, skip(str, 1))
+    if skip(str, pos) <= #str then
+        decode_error(skip(str, pos), "the end")
+    end
+    return value
+end
+
+return cjson
@@ -0,0 +1,118 @@
+--[[
+Runs a hekad sandbox script given as the first argument with mocked sandbox
+functions, see runSandbox in sandbox_test.go.
+
+Standard input is JSON {"config": {...}, "messages": [...]}, messages are
+tables of heka message variables with Fields. process_message is called for
+every message and its results are written to standard output as JSON list
+of {"status": ..., "error": ..., "message": ..., "injected": [...]}, where
+message is the message after write_message calls. Payloads injected by
+inject_payload are injected messages too.
+--]]
+
+local dir = string.match(arg[0], "^(.*)/[^/]*$") or "."
+package.path = dir .. "/?.lua;" .. package.path
+require "cjson"
+
+local ARRAY = {__jsontype = "array"}
+
+local input = cjson.decode(io.read("*a"))
+local config = input.config or {}
+local current, injected
+
+local function field_name(name)
+    return string.match(name, "^Fields%[(.+)%]$")
+end
+
+-- copy returns a deep copy of value, hekad copies injected messages
+local function copy(value)
+    if type(value) ~= "table" then
+        return value
+    end
+    local c = {}
+    for k, v in pairs(value) do
+        c[k] = copy(v)
+    end
+    return setmetatable(c, getmetatable(value))
+end
+
+function read_config(name)
+    local value = config[name]
+    if value == cjson.null then
+        return nil
+    end
+    return value
+end
+
+function read_message(name)
+    local field = field_name(name)
+    if field then
+        return (current.Fields or {})[field]
+    end
+    return current[name]
+end
+
+function write_message(name, value)
+    local field = field_name(name)
+    if field then
+        current.Fields = current.Fields or {}
+        current.Fields[field] = value
+    else
+        current[name] = value
+    end
+end
+
+function inject_message(msg)
+    injected[#injected + 1] = copy(msg)
+end
+
+-- inject_payload injects the concatenated arguments as the payload, its
+-- type and name are in Fields[payload_type] and Fields[payload_name]
+function inject_payload(payload_type, payload_name, ...)
+    local parts = {}
+    for i = 1, select("#", ...) do
+        parts[i] = tostring((select(i, ...)))
+    end
+    inject_message({
+        Payload = table.concat(parts),
+        Fields = {payload_type = payload_type, payload_name = payload_name}
+    })
+end
+
+-- field_types are value types of heka fields by Lua types
+local field_types = {string = 0, number = 3, boolean = 4}
+local field_names, next_field
+
+-- read_next_field iterates over fields of the message ordered by name
+function read_next_field()
+    if not field_names then
+        field_names, next_field = {}, 1
+        for name in pairs(current.Fields or {}) do
+            field_names[#field_names + 1] = name
+        end
+        table.sort(field_names)
+    end
+    local name = field_names[next_field]
+    if not name then
+        return nil
+    end
+    next_field = next_field + 1
+    local value = current.Fields[name]
+    return field_types[type(value)], name, value, "", 1
+end
+
+dofile(arg[1])
+
+local results = setmetatable({}, ARRAY)
+for i, msg in ipairs(input.messages or {}) do
+    current, injected = msg, setmetatable({}, ARRAY)
+    field_names = nil
+    local status, err = process_message()
+    results[i] = {
+        status = status,
+        error = err,
+        message = current,
+        injected = injected
+    }
+end
+io.write(cjson.encode(results))
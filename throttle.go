@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// throttleInterval is how often a throttle measures egress of hekad
+const throttleInterval = 100 * time.Millisecond
+
+// Throttle caps bytes written by a hekad process per second. The Kafka
+// client of hekad can not be limited, so hekad is paused by SIGSTOP when it
+// wrote more than the cap allows and continued by SIGCONT when it is within
+// the cap again. Written bytes are wchar of /proc/<pid>/io, they are mostly
+// messages sent into Kafka. A nil Throttle does not limit anything.
+type Throttle struct {
+	lgr     LOGGER
+	rate    int64 // bytes per second
+	procDir string
+	// debt is bytes written over the cap, bytes written below the cap are
+	// credited up to one second of the rate
+	debt float64
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewThrottle returns throttle capping egress at rate bytes per second,
+// it is nil when rate is zero
+func NewThrottle(lgr LOGGER, rate int64) *Throttle {
+	if rate == 0 {
+		return nil
+	}
+	return &Throttle{lgr: lgr, rate: rate, procDir: "/proc"}
+}
+
+// Start limits process pid until Stop is called
+func (t *Throttle) Start(pid int) {
+	if t == nil {
+		return
+	}
+	t.debt = 0
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go t.run(pid)
+}
+
+// Stop stops limiting, the process is left running
+func (t *Throttle) Stop() {
+	if t == nil || t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+}
+
+func (t *Throttle) run(pid int) {
+	defer close(t.done)
+	last, err := t.written(pid)
+	if err != nil {
+		t.lgr.Warnf("Egress of hekad is not limited: %v", err)
+		return
+	}
+	lastTime := time.Now()
+	ticker := time.NewTicker(throttleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			written, err := t.written(pid)
+			if err != nil {
+				// hekad exited
+				return
+			}
+			pause := t.pause(written-last, now.Sub(lastTime))
+			last, lastTime = written, now
+			if pause == 0 {
+				continue
+			}
+			if err = syscall.Kill(pid, syscall.SIGSTOP); err != nil {
+				return
+			}
+			select {
+			case <-time.After(pause):
+			case <-t.stop:
+				syscall.Kill(pid, syscall.SIGCONT)
+				return
+			}
+			if err = syscall.Kill(pid, syscall.SIGCONT); err != nil {
+				return
+			}
+			// the pause paid the debt, it is not credited
+			lastTime = time.Now()
+		}
+	}
+}
+
+// pause returns how long to pause the process which wrote bytes in elapsed
+// time to get within the cap
+func (t *Throttle) pause(bytes int64, elapsed time.Duration) time.Duration {
+	rate := float64(t.rate)
+	t.debt += float64(bytes) - rate*elapsed.Seconds()
+	if t.debt < -rate {
+		t.debt = -rate
+	}
+	if t.debt <= 0 {
+		return 0
+	}
+	pause := time.Duration(t.debt / rate * float64(time.Second))
+	t.debt = 0
+	return pause
+}
+
+// written returns bytes written by process pid since it started
+func (t *Throttle) written(pid int) (int64, error) {
+	file, err := os.Open(filepath.Join(t.procDir, strconv.Itoa(pid), "io"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "wchar:") {
+			return strconv.ParseInt(strings.TrimSpace(
+				strings.TrimPrefix(line, "wchar:")), 10, 64)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("No wchar in %q", file.Name())
+}
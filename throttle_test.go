@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottlePause(t *testing.T) {
+	assert.Nil(t, NewThrottle(logrus.New(), 0))
+	throttle := NewThrottle(logrus.New(), 1000)
+	// within the cap
+	assert.Equal(t, time.Duration(0), throttle.pause(100, 100*time.Millisecond))
+	// over the cap, hekad waits until the excess is sent at the rate
+	assert.Equal(t, 900*time.Millisecond,
+		throttle.pause(1000, 100*time.Millisecond))
+	// idle time is credited up to one second of the rate
+	assert.Equal(t, time.Duration(0), throttle.pause(0, 10*time.Second))
+	assert.Equal(t, time.Duration(0), throttle.pause(1000, 0))
+	assert.Equal(t, 500*time.Millisecond, throttle.pause(500, 0))
+}
+
+func TestThrottleWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkafeeder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	throttle := NewThrottle(logrus.New(), 1000)
+	throttle.procDir = dir
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "42"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "42", "io"),
+		[]byte("rchar: 100\nwchar: 2048\nsyscr: 5\n"), 0644))
+	written, err := throttle.written(42)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2048), written)
+	_, err = throttle.written(43)
+	assert.NotNil(t, err)
+
+	// a process which can not be measured is not limited
+	throttle.Start(43)
+	throttle.Stop()
+	var nilThrottle *Throttle
+	nilThrottle.Start(42)
+	nilThrottle.Stop()
+}
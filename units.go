@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)([a-z]*)`)
+	sizeRegexp     = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+
+	durationUnits = map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	sizeUnits = map[string]int64{
+		"":   1,
+		"B":  1,
+		"K":  1 << 10,
+		"KB": 1 << 10,
+		"M":  1 << 20,
+		"MB": 1 << 20,
+		"G":  1 << 30,
+		"GB": 1 << 30,
+		"T":  1 << 40,
+		"TB": 1 << 40,
+	}
+)
+
+// ParseRetention parses length of retention. It is a sequence of numbers
+// with unit postfix, e.g. "1w3d" or "36h". Supported postfixes are s, m, h, d
+// and w. A single number without postfix is assumed as time in hours.
+func ParseRetention(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, fmt.Errorf("Empty duration")
+	}
+	if _, err := strconv.ParseFloat(str, 64); err == nil {
+		str += "h"
+	}
+	var total time.Duration
+	for rest := str; rest != ""; {
+		match := durationRegexp.FindStringSubmatch(rest)
+		if match == nil {
+			return 0, fmt.Errorf("Invalid duration %q", str)
+		}
+		unit, ok := durationUnits[match[2]]
+		if !ok {
+			return 0, fmt.Errorf("Invalid duration %q: unknown unit %q", str,
+				match[2])
+		}
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration %q: %v", str, err)
+		}
+		// durations of more than about 292 years would overflow
+		if value >= float64(math.MaxInt64-total)/float64(unit) {
+			return 0, fmt.Errorf("Invalid duration %q: it is too long", str)
+		}
+		total += time.Duration(value * float64(unit))
+		rest = rest[len(match[0]):]
+	}
+	return total, nil
+}
+
+// ParseSize parses size in bytes with an optional postfix B, K(B), M(B),
+// G(B) or T(B). Postfixes are binary multiples, so 1KB is 1024 bytes.
+func ParseSize(str string) (int64, error) {
+	match := sizeRegexp.FindStringSubmatch(strings.TrimSpace(str))
+	if match == nil {
+		return 0, fmt.Errorf("Invalid size %q", str)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("Invalid size %q: unknown unit %q", str, match[2])
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q: %v", str, err)
+	}
+	if value >= math.MaxInt64/float64(unit) {
+		return 0, fmt.Errorf("Invalid size %q: it is too big", str)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// ParseRate parses rate in bytes per second. It is a size, see ParseSize,
+// optionally followed by /s, e.g. "1MB/s".
+func ParseRate(str string) (int64, error) {
+	rate, err := ParseSize(strings.TrimSuffix(strings.TrimSpace(str), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid rate %q", str)
+	}
+	return rate, nil
+}
@@ -92,6 +92,17 @@ func (w *LogWatcher) lookForKafkafeeders(root string) error {
 	return filepath.Walk(root, w.checkPath)
 }
 
+// DiscoverLogs adds all kafkafeeders found in logDir into logManager once,
+// it is used by commands which do not run the watcher
+func DiscoverLogs(lgr LOGGER, logDir string, logManager *LogManager) error {
+	w := &LogWatcher{
+		lgr:        lgr,
+		logDir:     logDir,
+		logManager: logManager,
+	}
+	return w.lookForKafkafeeders(logDir)
+}
+
 func (w *LogWatcher) Run() {
 	w.lgr.Infof("start scaning %s", w.logDir)
 	run := true